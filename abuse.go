@@ -0,0 +1,66 @@
+package panicbot
+
+import (
+	"sync"
+	"time"
+)
+
+// AbuseTracking configures how the bot reacts to users that repeatedly use panic commands without permission.
+// A Threshold below 1 disables tracking.
+type AbuseTracking struct {
+	// Threshold is the number of denied attempts within Window after which the user is ignored and reported.
+	Threshold int
+	Window    time.Duration
+	// Backoff is how long the bot ignores the user once the threshold has been reached.
+	Backoff time.Duration
+	// StartPanicAlert starts a panic alert vote about the user when they are reported.
+	StartPanicAlert bool
+}
+
+// abuseTracker counts denied attempts per user over a sliding window.
+type abuseTracker struct {
+	mu           sync.Mutex
+	attempts     map[string][]time.Time
+	ignoredUntil map[string]time.Time
+}
+
+func newAbuseTracker() *abuseTracker {
+	return &abuseTracker{
+		attempts:     make(map[string][]time.Time),
+		ignoredUntil: make(map[string]time.Time),
+	}
+}
+
+// deny records a denied attempt of userID. respond is false while the user is being ignored, report is true only
+// for the attempt that reached the threshold.
+func (a *abuseTracker) deny(userID string, tracking AbuseTracking, now time.Time) (respond bool, report bool) {
+	if tracking.Threshold < 1 {
+		return true, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if until, ok := a.ignoredUntil[userID]; ok {
+		if now.Before(until) {
+			return false, false
+		}
+		delete(a.ignoredUntil, userID)
+	}
+
+	attempts := a.attempts[userID]
+	kept := attempts[:0]
+	for _, attempt := range attempts {
+		if now.Sub(attempt) < tracking.Window {
+			kept = append(kept, attempt)
+		}
+	}
+	attempts = append(kept, now)
+
+	if len(attempts) < tracking.Threshold {
+		a.attempts[userID] = attempts
+		return true, false
+	}
+	delete(a.attempts, userID)
+	a.ignoredUntil[userID] = now.Add(tracking.Backoff)
+	return false, true
+}
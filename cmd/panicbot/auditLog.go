@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/internal/audit"
+)
+
+// MAX_AUDIT_ENTRIES is how many of the latest audit entries are kept in memory for /panicaudit.
+const MAX_AUDIT_ENTRIES = 1000
+
+// MAX_AUDIT_REASON_LENGTH is how many characters of the reason of an entry /panicaudit shows. Alert messages can be
+// longer than a whole Discord message.
+const MAX_AUDIT_REASON_LENGTH = 200
+
+// MAX_DISCORD_MESSAGE_LENGTH is the most characters Discord accepts in a message.
+const MAX_DISCORD_MESSAGE_LENGTH = 2000
+
+// recordAudit appends entry to the audit log. Failures are logged, they never stop the event itself.
+func (c *Container) recordAudit(entry audit.Entry) {
+	if c.Audit == nil {
+		return
+	}
+	err := c.Audit.Record(entry)
+	if err != nil {
+		c.Logger.Errorf("failed to record %s audit entry: %s", entry.Event, err.Error())
+	}
+}
+
+// voteAuditEntry builds the audit entry of an event of a vote.
+func voteAuditEntry(event, voteID string, voteData VoteData) audit.Entry {
+	entry := audit.Entry{
+		GuildID: voteData.GuildID,
+		Event:   event,
+		Actor:   voteData.CallingUser,
+		Target:  voteData.TargetUser,
+		Reason:  voteData.AlertMessage,
+		Details: map[string]string{
+			"voteID":        voteID,
+			"type":          voteData.PanicType,
+			"votes":         strconv.Itoa(len(voteData.Voters)),
+			"requiredVotes": strconv.Itoa(voteData.RequiredVotes),
+			"startedAt":     voteData.StartedAt.Format(time.RFC3339),
+			"expiresAt":     voteData.ExpiresAt.Format(time.RFC3339),
+		},
+	}
+	if voteData.PanicType == PANIC_BAN_VOTE_TYPE {
+		entry.Reason = voteData.BanReason
+	}
+	return entry
+}
+
+// auditAlert records the outcome of alerting one contact through one channel, e.g. sms.
+func (c *Container) auditAlert(guildID, channel, target, message string, err error) {
+	entry := audit.Entry{
+		GuildID: guildID,
+		Event:   audit.AlertDispatched,
+		Target:  target,
+		Reason:  message,
+		Details: map[string]string{"channel": channel},
+	}
+	if err != nil {
+		entry.Event = audit.AlertFailed
+		entry.Details["error"] = err.Error()
+	}
+	c.recordAudit(entry)
+}
+
+// PermissionDeniedCallback records a member that used a command they are not allowed to use.
+func (c *Container) PermissionDeniedCallback(guildID, userID, command string) {
+	c.recordAudit(audit.Entry{
+		GuildID: guildID,
+		Event:   audit.PermissionDenied,
+		Actor:   userID,
+		Target:  command,
+	})
+}
+
+// PanicAuditCallback handles /panicaudit. The returned text is only shown to the administrator that used it.
+func (c *Container) PanicAuditCallback(guildID, userID string, request panicbot.PanicAuditRequest) string {
+	if c.Audit == nil {
+		return "The audit log is disabled."
+	}
+	entries := c.Audit.Recent(guildID, request.Event, request.Limit)
+	if len(entries) == 0 {
+		return "No audit log entries found."
+	}
+	b := &strings.Builder{}
+	shown := 0
+	for _, entry := range entries {
+		line := &strings.Builder{}
+		fmt.Fprintf(line, "<t:%d:f> **%s**", entry.Time.Unix(), entry.Event)
+		if entry.Actor != "" {
+			fmt.Fprintf(line, " by <@%s>", entry.Actor)
+		}
+		if entry.Target != "" {
+			fmt.Fprintf(line, " target %s", truncate(entry.Target, MAX_AUDIT_REASON_LENGTH))
+		}
+		if entry.Reason != "" {
+			fmt.Fprintf(line, ": %s", truncate(entry.Reason, MAX_AUDIT_REASON_LENGTH))
+		}
+		line.WriteString("\n")
+		if utf8.RuneCountInString(b.String())+utf8.RuneCountInString(line.String()) > MAX_DISCORD_MESSAGE_LENGTH {
+			break
+		}
+		b.WriteString(line.String())
+		shown++
+	}
+	if shown == 0 {
+		return "The latest audit log entry is too long to be shown, see the audit log file instead."
+	}
+	return b.String()
+}
+
+// truncate shortens s to at most max characters, marking that it was cut with an ellipsis.
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
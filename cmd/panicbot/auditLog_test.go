@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/internal/audit"
+)
+
+func TestPanicAuditCallbackLongEntries(t *testing.T) {
+	log, err := audit.Open("", MAX_AUDIT_ENTRIES)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %s", err.Error())
+	}
+	c := &Container{Audit: log}
+	for i := 0; i < 20; i++ {
+		c.recordAudit(audit.Entry{
+			GuildID: "guild",
+			Event:   audit.VoteStarted,
+			Actor:   "1234",
+			Reason:  strings.Repeat("🚨 help ", 750),
+		})
+	}
+
+	reply := c.PanicAuditCallback("guild", "admin", panicbot.PanicAuditRequest{Limit: 20})
+	if reply == "" {
+		t.Fatalf("expected a reply")
+	}
+	if length := utf8.RuneCountInString(reply); length > MAX_DISCORD_MESSAGE_LENGTH {
+		t.Errorf("expected the reply to fit in a Discord message, got %d characters", length)
+	}
+	if lines := strings.Count(reply, "\n"); lines < 2 {
+		t.Errorf("expected the reasons to be shortened so that several entries are shown, got %d", lines)
+	}
+	if !strings.Contains(reply, "…") {
+		t.Errorf("expected the shortened reasons to end with an ellipsis")
+	}
+}
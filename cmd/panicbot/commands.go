@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/streemtech/panicbot"
+)
+
+// commandsCommand implements `panicbot commands purge`. It deletes the slash commands of panicbot from every
+// configured guild so that the bot can be uninstalled cleanly, and returns the exit code. The commands are registered
+// again the next time panicbot starts. Only the Discord settings of the config are needed, the rest of it is not
+// checked.
+func (c *Container) commandsCommand(args []string) int {
+	if len(args) != 1 || args[0] != "purge" {
+		fmt.Fprintln(os.Stderr, "usage: panicbot commands purge")
+		return 2
+	}
+	conf, err := c.readConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %s\n", err.Error())
+		return 1
+	}
+	problems := conf.validateDiscord()
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem.Error())
+		}
+		return 1
+	}
+	purgeArgs := c.purgeArgs(*conf)
+	discordImpl, err := panicbot.NewDiscord(purgeArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create Discord session: %s\n", err.Error())
+		return 1
+	}
+	err = discordImpl.PurgeCommands(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to purge slash commands: %s\n", err.Error())
+		return 1
+	}
+	fmt.Printf("deleted the slash commands of panicbot from %d guild(s)\n", len(purgeArgs.Guilds))
+	return 0
+}
+
+// purgeArgs returns the arguments of a Discord session that can purge the slash commands of every guild of conf.
+// The session never starts, so the callbacks are never called and the voting rules are left out.
+func (c *Container) purgeArgs(conf Config) *panicbot.DiscordImplArgs {
+	guilds := make([]panicbot.GuildArgs, 0)
+	for _, guild := range conf.guilds() {
+		guilds = append(guilds, panicbot.GuildArgs{
+			GuildID:          guild.GuildID,
+			PrimaryChannelID: guild.PrimaryChannelID,
+		})
+	}
+	return &panicbot.DiscordImplArgs{
+		Guilds:                guilds,
+		BotToken:              conf.DiscordBotToken,
+		Logger:                c.Logger,
+		EmbedReactionCallback: func(userID, buttonID string) {},
+		PanicAlertCallback: func(guildID, userID, message string) (panicbot.VoteNotice, error) {
+			return panicbot.VoteNotice{}, fmt.Errorf("panicbot is purging its slash commands")
+		},
+		PanicBanCallback: func(guildID, userID, targetUserID, reason string, days float64) (panicbot.VoteNotice, error) {
+			return panicbot.VoteNotice{}, fmt.Errorf("panicbot is purging its slash commands")
+		},
+	}
+}
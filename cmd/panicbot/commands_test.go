@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/discordtest"
+)
+
+func TestPurgeOnlyNeedsDiscordSettings(t *testing.T) {
+	const otherGuildID = "111111111111111119"
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte("DiscordBotToken: test\nGuildID: \""+testGuildID+"\"\nGuilds:\n  - GuildID: \""+otherGuildID+"\"\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err.Error())
+	}
+	t.Setenv("CONFIG", path)
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	c := &Container{Logger: logger}
+
+	conf, err := c.readConfig()
+	if err != nil {
+		t.Fatalf("readConfig failed: %s", err.Error())
+	}
+	if problems := conf.validateDiscord(); len(problems) != 0 {
+		t.Fatalf("expected the Discord settings to be valid, got %v", problems)
+	}
+	if problems := conf.validate(); len(problems) == 0 {
+		t.Fatalf("expected the config to be incomplete for running the bot")
+	}
+
+	server := discordtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddGuild(testGuildID, "test guild", 0)
+	server.AddGuild(otherGuildID, "other guild", 0)
+	server.AddCommand(testGuildID, &discordgo.ApplicationCommand{Name: "panicalert"})
+	server.AddCommand(otherGuildID, &discordgo.ApplicationCommand{Name: "panicban"})
+	args := c.purgeArgs(*conf)
+	args.APIBaseURL = server.URL
+	d, err := panicbot.NewDiscord(args)
+	if err != nil {
+		t.Fatalf("failed to create Discord: %s", err.Error())
+	}
+	err = d.PurgeCommands(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeCommands failed: %s", err.Error())
+	}
+	for _, guildID := range []string{testGuildID, otherGuildID} {
+		if commands := server.Commands(guildID); len(commands) != 0 {
+			t.Errorf("expected no commands to be left in guild %s, got %d", guildID, len(commands))
+		}
+	}
+}
+
+func TestValidateDiscord(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     Config
+		problems int
+	}{
+		{name: "valid", conf: Config{DiscordBotToken: "test", GuildID: testGuildID}, problems: 0},
+		{name: "missing token", conf: Config{GuildID: testGuildID}, problems: 1},
+		{name: "no guild", conf: Config{DiscordBotToken: "test"}, problems: 1},
+		{name: "invalid guild ID", conf: Config{DiscordBotToken: "test", Guilds: []GuildConfig{{GuildID: "general"}}}, problems: 1},
+		{name: "duplicate guild", conf: Config{DiscordBotToken: "test", GuildID: testGuildID, Guilds: []GuildConfig{{GuildID: testGuildID}}}, problems: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if problems := test.conf.validateDiscord(); len(problems) != test.problems {
+				t.Errorf("expected %d problem(s), got %v", test.problems, problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ENV_PREFIX is prepended to the path of a config field to get the environment variable that overrides it, e.g.
+// PANICBOT_ALERTINGMETHODS_TWILIO_APISECRET overrides AlertingMethods.Twilio.APISecret.
+const ENV_PREFIX = "PANICBOT_"
+
+// ENV_FILE_SUFFIX marks an environment variable that holds the path of a file to read the value from instead of the
+// value itself, e.g. a mounted Kubernetes secret.
+const ENV_FILE_SUFFIX = "_FILE"
+
+// secretConfigFields are redacted whenever the config is logged.
+var secretConfigFields = map[string]bool{
+	"DISCORDBOTTOKEN":                     true,
+	"ALERTINGMETHODS_TWILIO_APISECRET":    true,
+	"ALERTINGMETHODS_TWILIO_AUTHTOKEN":    true,
+	"ALERTINGMETHODS_EMAIL_AUTH_PASSWORD": true,
+}
+
+// walkConfig calls fn for every field of v that holds a value, with the upper case path of the field separated by
+// underscores. Structs and lists of structs are walked into instead of being passed to fn, the index of a list
+// entry is part of the path, e.g. GUILDS_0_GUILDID.
+func walkConfig(v reflect.Value, path string, fn func(path string, field reflect.Value) error) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct {
+		for i := 0; i < v.Len(); i++ {
+			err := walkConfig(v.Index(i), fmt.Sprintf("%s_%d", path, i), fn)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v.Kind() != reflect.Struct {
+		return fn(path, v)
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.ToUpper(field.Name)
+		if path != "" {
+			name = path + "_" + name
+		}
+		err := walkConfig(v.Field(i), name, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnvOverrides replaces the fields of conf that have a matching environment variable. NAME_FILE reads the value
+// from the file it points to and takes precedence over NAME. Lists are comma separated and maps are written as
+// key=value pairs separated by commas.
+func applyEnvOverrides(conf *Config, lookupEnv func(key string) (string, bool)) error {
+	return walkConfig(reflect.ValueOf(conf).Elem(), "", func(path string, field reflect.Value) error {
+		name := ENV_PREFIX + path
+		value, ok := lookupEnv(name)
+		if file, fileOK := lookupEnv(name + ENV_FILE_SUFFIX); fileOK {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s%s: %w", name, ENV_FILE_SUFFIX, err)
+			}
+			value, ok = strings.TrimRight(string(content), "\r\n"), true
+		}
+		if !ok {
+			return nil
+		}
+		err := setConfigField(field, value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String || field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map type %s", field.Type())
+		}
+		m := make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, val, found := strings.Cut(pair, "=")
+			if !found {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		field.Set(reflect.ValueOf(m))
+	case reflect.Ptr:
+		// Optional fields are pointers so that an unset field can be told apart from a zero value.
+		elem := reflect.New(field.Type().Elem())
+		err := setConfigField(elem.Elem(), value)
+		if err != nil {
+			return err
+		}
+		field.Set(elem)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// redacted returns a copy of conf with the secrets replaced so that it can be logged.
+func (conf Config) redacted() Config {
+	walkConfig(reflect.ValueOf(&conf).Elem(), "", func(path string, field reflect.Value) error {
+		if secretConfigFields[path] && field.String() != "" {
+			field.SetString("REDACTED")
+		}
+		return nil
+	})
+	return conf
+}
+
+// logEffectiveConfig logs the config after the environment overrides have been applied, without its secrets.
+func (c *Container) logEffectiveConfig(conf Config) {
+	effective, err := json.Marshal(conf.redacted())
+	if err != nil {
+		c.Logger.Errorf("failed to marshal effective config: %s", err.Error())
+		return
+	}
+	c.Logger.Infof("effective config: %s", effective)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/internal/audit"
+)
+
+// guildSetting is a part of the Voting section that administrators can change with /panicconfig. Changed settings
+// are kept in the VoteStore and applied on top of the Voting section of the config file.
+type guildSetting struct {
+	name  string
+	apply func(voting *Voting, value string) error
+	show  func(voting Voting) string
+}
+
+func intSetting(name string, field func(voting *Voting) *int) guildSetting {
+	return guildSetting{
+		name: name,
+		apply: func(voting *Voting, value string) error {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			*field(voting) = i
+			return nil
+		},
+		show: func(voting Voting) string {
+			return strconv.Itoa(*field(&voting))
+		},
+	}
+}
+
+func durationSetting(name string, field func(voting *Voting) *string) guildSetting {
+	return guildSetting{
+		name: name,
+		apply: func(voting *Voting, value string) error {
+			_, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a duration, e.g. 5m", value)
+			}
+			*field(voting) = value
+			return nil
+		},
+		show: func(voting Voting) string {
+			return *field(&voting)
+		},
+	}
+}
+
+// listSetting takes a comma separated list, none empties the list.
+func listSetting(name string, field func(voting *Voting) *[]string) guildSetting {
+	return guildSetting{
+		name: name,
+		apply: func(voting *Voting, value string) error {
+			list := make([]string, 0)
+			if !strings.EqualFold(strings.TrimSpace(value), "none") {
+				for _, item := range strings.Split(value, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						list = append(list, item)
+					}
+				}
+			}
+			*field(voting) = list
+			return nil
+		},
+		show: func(voting Voting) string {
+			list := *field(&voting)
+			if len(list) == 0 {
+				return "none"
+			}
+			return strings.Join(list, ", ")
+		},
+	}
+}
+
+// guildSettings lists every setting that can be changed with /panicconfig. Discord allows at most 25 of them.
+var guildSettings = []guildSetting{
+	intSetting("requiredvotes.panicalert", func(v *Voting) *int { return &v.RequiredVotes.PanicAlert }),
+	intSetting("requiredvotes.panicban", func(v *Voting) *int { return &v.RequiredVotes.PanicBan }),
+	durationSetting("votetimers.panicalert", func(v *Voting) *string { return &v.VoteTimers.PanicAlertVoteTimer }),
+	durationSetting("votetimers.panicban", func(v *Voting) *string { return &v.VoteTimers.PanicBanVoteTimer }),
+	listSetting("allowedtovote.panicalert.users", func(v *Voting) *[]string { return &v.AllowedToVote.PanicAlert.Users }),
+	listSetting("allowedtovote.panicalert.roles", func(v *Voting) *[]string { return &v.AllowedToVote.PanicAlert.Roles }),
+	listSetting("allowedtovote.panicalert.permissions", func(v *Voting) *[]string { return &v.AllowedToVote.PanicAlert.Permissions }),
+	listSetting("allowedtovote.panicban.users", func(v *Voting) *[]string { return &v.AllowedToVote.PanicBan.Users }),
+	listSetting("allowedtovote.panicban.roles", func(v *Voting) *[]string { return &v.AllowedToVote.PanicBan.Roles }),
+	listSetting("allowedtovote.panicban.permissions", func(v *Voting) *[]string { return &v.AllowedToVote.PanicBan.Permissions }),
+	listSetting("contactonvote.discord.users", func(v *Voting) *[]string { return &v.ContactOnVote.Discord.Users }),
+	listSetting("contactonvote.discord.roles", func(v *Voting) *[]string { return &v.ContactOnVote.Discord.Roles }),
+	listSetting("contactonvote.twilio.phonenumbers", func(v *Voting) *[]string { return &v.ContactOnVote.Twilio.PhoneNumbers }),
+	listSetting("contactonvote.email.addresses", func(v *Voting) *[]string { return &v.ContactOnVote.Email.Addresses }),
+}
+
+func findGuildSetting(name string) (guildSetting, bool) {
+	for _, setting := range guildSettings {
+		if setting.name == name {
+			return setting, true
+		}
+	}
+	return guildSetting{}, false
+}
+
+func guildSettingNames() []string {
+	names := make([]string, 0, len(guildSettings))
+	for _, setting := range guildSettings {
+		names = append(names, setting.name)
+	}
+	return names
+}
+
+// applyGuildSettings returns voting with the changed settings applied.
+func applyGuildSettings(voting Voting, settings map[string]string) (Voting, error) {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		setting, ok := findGuildSetting(name)
+		if !ok {
+			return voting, fmt.Errorf("unknown setting %s", name)
+		}
+		err := setting.apply(&voting, settings[name])
+		if err != nil {
+			return voting, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return voting, nil
+}
+
+// effectiveVoting applies the changed settings of the guild to voting. Settings that no longer apply, e.g. after
+// the config file was changed, are logged and skipped so that the config file keeps working.
+func (c *Container) effectiveVoting(guildID string, voting Voting, settings map[string]string) Voting {
+	if len(settings) == 0 {
+		return voting
+	}
+	effective, err := applyGuildSettings(voting, settings)
+	if err != nil {
+		c.Logger.Errorf("ignoring the /panicconfig settings of guild %s: %s", guildID, err.Error())
+		return voting
+	}
+	return effective
+}
+
+// loadGuildSettings applies the settings that were changed with /panicconfig before the bot was restarted.
+func (c *Container) loadGuildSettings() error {
+	settings, err := c.Store.LoadGuildSettings()
+	if err != nil {
+		return err
+	}
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.settings = settings
+	for guildID, guild := range c.guilds {
+		guild.Voting = c.effectiveVoting(guildID, c.configVoting(guildID), settings[guildID])
+		c.guilds[guildID] = guild
+	}
+	return nil
+}
+
+// configVoting returns the Voting section of the guild as found in the config file, without the changes made with
+// /panicconfig.
+func (c *Container) configVoting(guildID string) Voting {
+	for _, guild := range c.Config.guilds() {
+		if guild.GuildID == guildID {
+			return guild.Voting
+		}
+	}
+	return Voting{}
+}
+
+// PanicConfigCallback handles /panicconfig. The returned text is only shown to the administrator that used it.
+func (c *Container) PanicConfigCallback(guildID, userID string, request panicbot.PanicConfigRequest) string {
+	switch request.Action {
+	case panicbot.PanicConfigView:
+		return c.describeGuildSettings(guildID)
+	case panicbot.PanicConfigSet, panicbot.PanicConfigReset:
+		response, err := c.changeGuildSetting(guildID, userID, request)
+		if err != nil {
+			c.Logger.WithFields(log.Fields{
+				"guildID": guildID,
+				"userID":  userID,
+				"setting": request.Setting,
+			}).Warnf("rejected /panicconfig change: %s", err.Error())
+			return fmt.Sprintf("The setting was not changed: %s", err.Error())
+		}
+		return response
+	default:
+		return fmt.Sprintf("Unknown /panicconfig subcommand %s.", request.Action)
+	}
+}
+
+func (c *Container) describeGuildSettings(guildID string) string {
+	c.configMu.RLock()
+	voting := c.guilds[guildID].Voting
+	changed := c.settings[guildID]
+	c.configMu.RUnlock()
+
+	b := &strings.Builder{}
+	b.WriteString("**Panicbot settings**\n")
+	for _, setting := range guildSettings {
+		marker := ""
+		if _, ok := changed[setting.name]; ok {
+			marker = " *(changed with /panicconfig)*"
+		}
+		fmt.Fprintf(b, "`%s`: %s%s\n", setting.name, setting.show(voting), marker)
+	}
+	return b.String()
+}
+
+// changeGuildSetting validates, persists and applies a /panicconfig set or reset. Every change is logged and
+// announced in the primary channel of the guild together with the administrator that made it.
+func (c *Container) changeGuildSetting(guildID, userID string, request panicbot.PanicConfigRequest) (string, error) {
+	setting, ok := findGuildSetting(request.Setting)
+	if !ok {
+		return "", fmt.Errorf("unknown setting %s", request.Setting)
+	}
+	// Changes are serialized with config reloads so that neither overwrites the other.
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	c.configMu.RLock()
+	current, ok := c.guilds[guildID]
+	base := c.configVoting(guildID)
+	settings := copySettings(c.settings[guildID])
+	c.configMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("guild %s is not configured", guildID)
+	}
+
+	if request.Action == panicbot.PanicConfigReset {
+		delete(settings, setting.name)
+	} else {
+		settings[setting.name] = request.Value
+	}
+	voting, err := applyGuildSettings(base, settings)
+	if err != nil {
+		return "", err
+	}
+	v := &configValidator{}
+	v.voting("Voting", voting)
+	if len(v.problems) > 0 {
+		problems := make([]string, 0, len(v.problems))
+		for _, problem := range v.problems {
+			problems = append(problems, problem.Error())
+		}
+		return "", fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	rules, err := voting.commandRules()
+	if err != nil {
+		return "", err
+	}
+
+	err = c.Store.SaveGuildSettings(guildID, settings)
+	if err != nil {
+		return "", fmt.Errorf("failed to save settings: %w", err)
+	}
+	oldValue := setting.show(current.Voting)
+	newValue := setting.show(voting)
+	c.configMu.Lock()
+	if c.settings == nil {
+		c.settings = make(map[string]map[string]string)
+	}
+	c.settings[guildID] = settings
+	current.Voting = voting
+	c.guilds[guildID] = current
+	c.configMu.Unlock()
+	err = c.Discord.UpdateCommandRules(guildID, rules)
+	if err != nil {
+		c.Logger.Errorf("failed to update command rules: %s", err.Error())
+	}
+
+	c.Logger.WithFields(log.Fields{
+		"guildID":  guildID,
+		"userID":   userID,
+		"action":   request.Action,
+		"setting":  setting.name,
+		"oldValue": oldValue,
+		"newValue": newValue,
+	}).Info("guild setting changed with /panicconfig")
+	c.recordAudit(audit.Entry{
+		GuildID: guildID,
+		Event:   audit.SettingChanged,
+		Actor:   userID,
+		Target:  setting.name,
+		Details: map[string]string{
+			"action":   request.Action,
+			"oldValue": oldValue,
+			"newValue": newValue,
+		},
+	})
+	err = c.Discord.SendChannelMessage(guildID, "", fmt.Sprintf("<@%s> changed the panicbot setting `%s` from %s to %s.", userID, setting.name, oldValue, newValue))
+	if err != nil {
+		c.Logger.Errorf("failed to announce setting change: %s", err.Error())
+	}
+	return fmt.Sprintf("`%s` is now %s.", setting.name, newValue), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streemtech/panicbot/ticker"
+)
+
+// CREDENTIAL_CHECK_INTERVAL is how often the Twilio and email credentials are checked again for /readyz.
+const CREDENTIAL_CHECK_INTERVAL = time.Minute * 5
+
+// readiness holds the checks /readyz runs. A check returns why the bot is not ready, or nil.
+type readiness struct {
+	mu     sync.Mutex
+	names  []string
+	checks map[string]func() error
+}
+
+// set adds or replaces the check called name.
+func (r *readiness) set(name string, check func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.checks == nil {
+		r.checks = make(map[string]func() error)
+	}
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = check
+}
+
+// setResult replaces the check called name with the stored result of a check that already ran.
+func (r *readiness) setResult(name string, err error) {
+	r.set(name, func() error { return err })
+}
+
+// report runs every check in the order they were added. It returns one line per check and whether all of them passed.
+func (r *readiness) report() (string, bool) {
+	r.mu.Lock()
+	names := append([]string(nil), r.names...)
+	checks := make([]func() error, 0, len(names))
+	for _, name := range names {
+		checks = append(checks, r.checks[name])
+	}
+	r.mu.Unlock()
+
+	b := &strings.Builder{}
+	ready := true
+	for i, check := range checks {
+		err := check()
+		if err != nil {
+			ready = false
+			fmt.Fprintf(b, "%s: %s\n", names[i], err.Error())
+			continue
+		}
+		fmt.Fprintf(b, "%s: ok\n", names[i])
+	}
+	return b.String(), ready
+}
+
+// handleHealthz reports that the process is running. It does not depend on Discord or the alerting methods so that
+// a bot waiting for them is not restarted.
+func (c *Container) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the bot is connected to Discord, has registered its slash commands and can reach
+// the alerting methods. It answers 503 Service Unavailable with the failing checks otherwise.
+func (c *Container) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report, ready := c.readiness.report()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, report)
+}
+
+// checkCredentials checks the Twilio and email credentials now and every CREDENTIAL_CHECK_INTERVAL after. The
+// results are stored instead of checking on every request to /readyz, which would hit the Twilio API on every probe.
+func (c *Container) checkCredentials() (cancel func()) {
+	check := func() {
+		err := c.Twilio.CheckCredentials()
+		if err != nil {
+			c.Logger.Errorf("Twilio credential check failed: %s", err.Error())
+		}
+		c.readiness.setResult("twilio", err)
+		if c.Email == nil {
+			return
+		}
+		err = c.Email.CheckCredentials()
+		if err != nil {
+			c.Logger.Errorf("email credential check failed: %s", err.Error())
+		}
+		c.readiness.setResult("email", err)
+	}
+	c.readiness.setResult("twilio", fmt.Errorf("credentials have not been checked yet"))
+	if c.Email != nil {
+		c.readiness.setResult("email", fmt.Errorf("credentials have not been checked yet"))
+	}
+	go check()
+	return ticker.SimpleTickerFunc(CREDENTIAL_CHECK_INTERVAL, check)
+}
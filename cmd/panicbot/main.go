@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/internal/audit"
+	"github.com/streemtech/panicbot/internal/metrics"
+	"github.com/streemtech/panicbot/internal/multierror"
 	"github.com/streemtech/panicbot/internal/slice"
 	"sigs.k8s.io/yaml"
 )
@@ -16,6 +25,13 @@ import (
 const PANIC_BAN_VOTE_TYPE = "panicban"
 const PANIC_ALERT_VOTE_TYPE = "panicalert"
 
+const PHONE_METHOD_SMS = "sms"
+const PHONE_METHOD_CALL = "call"
+const PHONE_METHOD_BOTH = "both"
+
+// GRACE_PERIOD is how long a user that lost a voting role may still vote on panic alerts.
+const GRACE_PERIOD = time.Minute * 30
+
 // TODO: Change debug logs to info.
 
 // type Boot interface {
@@ -34,7 +50,40 @@ type Config struct {
 	GuildID          string
 	PrimaryChannelID string
 	AlertingMethods  AlertingMethods
+	Server           Server
+	Metrics          Metrics
+	Storage          Storage
 	Voting           Voting
+	// ShutdownTimeout is how long panicbot may take to shut down gracefully. Defaults to thirty seconds.
+	ShutdownTimeout string
+	// Greeting and Farewell are sent to the primary channel of every guild when panicbot starts and stops. Nothing
+	// is sent when they are empty.
+	Greeting string
+	Farewell string
+	Audit    Audit
+	// Guilds lists the guilds served by the bot. The top level GuildID, PrimaryChannelID and Voting configure one
+	// more guild, so configs written for a single guild keep working.
+	Guilds []GuildConfig
+}
+
+// GuildConfig holds the settings of one guild.
+type GuildConfig struct {
+	GuildID          string
+	PrimaryChannelID string
+	Voting           Voting
+}
+
+// guilds returns every configured guild, including the one configured at the top level.
+func (conf Config) guilds() []GuildConfig {
+	guilds := make([]GuildConfig, 0, len(conf.Guilds)+1)
+	if conf.GuildID != "" {
+		guilds = append(guilds, GuildConfig{
+			GuildID:          conf.GuildID,
+			PrimaryChannelID: conf.PrimaryChannelID,
+			Voting:           conf.Voting,
+		})
+	}
+	return append(guilds, conf.Guilds...)
 }
 
 type ContactOnVote struct {
@@ -42,21 +91,59 @@ type ContactOnVote struct {
 		Users []string
 		Roles []string
 	}
-	Twilio struct {
-		PhoneNumbers []string
-	}
-	Email struct {
+	Twilio TwilioContacts
+	Email  struct {
 		Addresses []string
 	}
 }
 
+type TwilioContacts struct {
+	PhoneNumbers []string
+	// Methods optionally maps a phone number to PHONE_METHOD_SMS, PHONE_METHOD_CALL or PHONE_METHOD_BOTH.
+	Methods map[string]string
+}
+
+// method returns how phoneNumber should be contacted, defaulting to a text message.
+func (t TwilioContacts) method(phoneNumber string) string {
+	method, ok := t.Methods[phoneNumber]
+	if !ok || method == "" {
+		return PHONE_METHOD_SMS
+	}
+	return strings.ToLower(method)
+}
+
 type Container struct {
-	Config      Config
-	Logger      *log.Logger
-	Discord     panicbot.Discord
-	Twilio      panicbot.Twilio
-	GracePeriod map[string]time.Time
-	VoteTracker map[string]VoteData
+	// Config may only be written by loadConfig and reloadConfig. The Voting section of a guild changes while the
+	// bot is running and must be read through voting().
+	Config   Config
+	configMu sync.RWMutex
+	// guilds maps the ID of every configured guild to its config with the /panicconfig settings applied, settings
+	// holds those settings by guild. Both are guarded by configMu.
+	guilds   map[string]GuildConfig
+	settings map[string]map[string]string
+	reloadMu sync.Mutex
+	Logger   *log.Logger
+	Discord  panicbot.Discord
+	Twilio   panicbot.Twilio
+	Email    panicbot.Email
+	Store    VoteStore
+	Votes    *VoteManager
+	Audit    *audit.Log
+	// alerts counts the alerts that are still being sent so that shutdown can wait for them. alertsMu guards
+	// drainingAlerts, which is set once shutdown waits for alerts and no more may be added to alerts.
+	alerts         sync.WaitGroup
+	alertsMu       sync.Mutex
+	drainingAlerts bool
+	// timers holds the pending vote expiry and grace period timers so that shutdown can stop them before the store
+	// is closed. timersMu guards timers and timersStopped, runningTimers counts the timer functions that are executing.
+	timers        map[*time.Timer]bool
+	timersMu      sync.Mutex
+	timersStopped bool
+	runningTimers sync.WaitGroup
+	// tickers holds the cancel functions of the running tickers.
+	tickers []func()
+	// readiness holds the checks of /readyz.
+	readiness readiness
 }
 
 type Email struct {
@@ -66,6 +153,8 @@ type Email struct {
 		Password string
 		Host     string
 	}
+	// Security is either starttls or tls.
+	Security       string
 	From           string
 	DefaultMessage string
 }
@@ -80,23 +169,47 @@ type RateLimit struct {
 	}
 }
 
+type Server struct {
+	// ListenAddress is the address the HTTP server listens on, e.g. ":8080". The server is disabled when empty.
+	ListenAddress string
+}
+
+type Metrics struct {
+	// ListenAddress is the address /metrics is served on for Prometheus, e.g. ":9090". Metrics are not served when
+	// empty.
+	ListenAddress string
+}
+
+type Audit struct {
+	// Path to the JSON Lines file the audit log is appended to. The audit log is only kept in memory when empty.
+	Path string
+}
+
+type Storage struct {
+	// Path to the file the active votes and /panicconfig settings are kept in. They are only kept in memory when empty.
+	Path string
+}
+
 type Twilio struct {
 	AccountSID        string
 	APIKey            string
 	APISecret         string
 	TwilioPhoneNumber string
+	// CallAttempts is how many times a call is placed before giving up, 1 disables retries. Defaults to three.
+	CallAttempts *int
+	// AuthToken is used to verify that status callbacks were sent by Twilio.
+	AuthToken string
+	// StatusCallbackURL is the public URL Twilio reports the delivery status of text messages to.
+	StatusCallbackURL string
+	// MaxRetries is how many times an undelivered text message is sent again, 0 disables retries. Defaults to three.
+	MaxRetries   *int
+	RetryBackoff string
 }
 
 type Voting struct {
 	AllowedToVote struct {
-		PanicAlert struct {
-			Users []string
-			Roles []string
-		}
-		PanicBan struct {
-			Users []string
-			Roles []string
-		}
+		PanicAlert panicbot.PermissionRule
+		PanicBan   panicbot.PermissionRule
 	}
 	Cooldown struct {
 		PanicAlert string
@@ -110,15 +223,29 @@ type Voting struct {
 		PanicAlertVoteTimer string
 		PanicBanVoteTimer   string
 	}
-	ContactOnVote ContactOnVote
-	RateLimit     RateLimit
+	ContactOnVote   ContactOnVote
+	RateLimit       RateLimit
+	UnauthorizedUse UnauthorizedUse
+}
+
+type UnauthorizedUse struct {
+	Threshold       int
+	Window          string
+	Backoff         string
+	StartPanicAlert bool
 }
 
 type VoteData struct {
+	// GuildID is the guild the vote was started in.
+	GuildID      string
 	AlertMessage string
 	CallingUser  string
 	PanicType    string
 	Voters       map[string]bool
+	// RequiredVotes is copied from the config when the vote starts.
+	RequiredVotes int
+	StartedAt     time.Time
+	ExpiresAt     time.Time
 
 	// Optional, only for Ban
 	Days       float64
@@ -126,30 +253,130 @@ type VoteData struct {
 	TargetUser string
 }
 
-func (c *Container) SendText(message string) {
-	for _, phoneNumber := range c.Config.Voting.ContactOnVote.Twilio.PhoneNumbers {
-		c.Twilio.SendMessage(phoneNumber, message)
+// copy returns a VoteData that does not share its Voters with v.
+func (v VoteData) copy() VoteData {
+	voters := make(map[string]bool, len(v.Voters))
+	for userID, voted := range v.Voters {
+		voters[userID] = voted
 	}
+	v.Voters = voters
+	return v
 }
 
-func (c *Container) PanicAlertCallback(message string) {
-	// TODO write logic for starting a panicalert vote
-	// TODO if enough votes then call SendDM method passing the information from the config.ContactOnVote {Discord {}} struct
-	allUsers, err := c.Discord.GetAllGuildMembers()
-	if err != nil {
-		c.Logger.Errorf("failed to get all guild members: %s", err.Error())
+// SendPhoneAlerts texts and/or calls every phone number in ContactOnVote.Twilio of the guild depending on its
+// configured method.
+func (c *Container) SendPhoneAlerts(guildID, message string) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs *multierror.Error
+	send := func(channel, phoneNumber string, f func(toNumber, message string) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := f(phoneNumber, message)
+			c.auditAlert(guildID, channel, phoneNumber, message, err)
+			if err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, fmt.Errorf("twilio: %w", err))
+				mu.Unlock()
+			}
+		}()
 	}
-	for _, v := range allUsers {
-		if hasVotePermissions(v.UserID, v.Roles, c.Config.Voting.AllowedToVote.PanicAlert.Users, c.Config.Voting.AllowedToVote.PanicAlert.Roles) || c.RoleRemovedCheck(v.UserID) {
-			c.Discord.SendDM(v.UserID, message)
+	contacts := c.voting(guildID).ContactOnVote.Twilio
+	for _, phoneNumber := range contacts.PhoneNumbers {
+		if phoneNumber == "" {
+			continue
+		}
+		method := contacts.method(phoneNumber)
+		if method == PHONE_METHOD_SMS || method == PHONE_METHOD_BOTH {
+			send(PHONE_METHOD_SMS, phoneNumber, c.Twilio.SendMessage)
+		}
+		if method == PHONE_METHOD_CALL || method == PHONE_METHOD_BOTH {
+			send(PHONE_METHOD_CALL, phoneNumber, c.Twilio.Call)
 		}
 	}
-	// TODO if enough votes then call Twilio API to text/call the number from the config.ContactOnVote {Twilio {}} struct
-	// TODO if enough votes then call Email handler to email the addresses from the config.ContactOnVote {Email {}} struct
-	// TODO write logic for if vote fails. No one is contacted but perhaps a message is sent to the PrimaryChannel. Use SendChannelMessage
+	wg.Wait()
+	return errs.ErrorOrNil()
 }
 
-func (c *Container) PanicBanCallback(userID, targetUserID, reason string, days float64) {
+// DeliveryFailureCallback lets the primary channel of every guild that contacts the phone number know that a text
+// message could not be delivered.
+func (c *Container) DeliveryFailureCallback(delivery panicbot.MessageDelivery) {
+	for _, guildID := range c.Discord.GuildIDs() {
+		if !slice.Contains(c.voting(guildID).ContactOnVote.Twilio.PhoneNumbers, delivery.To) {
+			continue
+		}
+		err := c.Discord.SendChannelMessage(guildID, "", fmt.Sprintf("⚠️ Text message alert to %s could not be delivered after %d attempts (status: %s).", delivery.To, delivery.Attempt, delivery.Status))
+		if err != nil {
+			c.Logger.Errorf("failed to report undelivered text message: %s", err.Error())
+		}
+	}
+}
+
+func (c *Container) SendEmail(guildID, message string) error {
+	body := message
+	if c.Config.AlertingMethods.Email.DefaultMessage != "" {
+		body = fmt.Sprintf("%s\n\n%s", c.Config.AlertingMethods.Email.DefaultMessage, message)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs *multierror.Error
+	for _, address := range c.voting(guildID).ContactOnVote.Email.Addresses {
+		if address == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			err := c.Email.SendMail([]string{address}, "🚨 Panic Alert 🚨", body)
+			c.auditAlert(guildID, "email", address, message, err)
+			if err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, fmt.Errorf("email: %w", err))
+				mu.Unlock()
+			}
+		}(address)
+	}
+	wg.Wait()
+	return errs.ErrorOrNil()
+}
+
+func (c *Container) PanicAlertCallback(guildID, userID, message string) (panicbot.VoteNotice, error) {
+	content := fmt.Sprintf("User <@%s> has triggered a Panic Alert vote", userID)
+	description := fmt.Sprintf("**Message:** %s\n\n**Action Needed:** Click the Confirm Alert button to cast your vote. The administrators will be contacted once enough votes are received.\n\n**Ignore this message if you do not want to vote.**", message)
+	titleText := "🚨 Panic Alert Vote 🚨"
+	buttonLabel := "Confirm Alert"
+
+	voteID := uuid.New().String()
+
+	voting := c.voting(guildID)
+	voteTime, err := time.ParseDuration(voting.VoteTimers.PanicAlertVoteTimer)
+	if err != nil {
+		c.Logger.Errorf("failed to parse alert vote duration: %s ,setting to default time of five minutes", err.Error())
+		voteTime = time.Minute * 5
+	}
+	now := time.Now()
+	err = c.startVote(voteID, VoteData{
+		GuildID:       guildID,
+		Voters:        make(map[string]bool),
+		AlertMessage:  message,
+		CallingUser:   userID,
+		PanicType:     PANIC_ALERT_VOTE_TYPE,
+		RequiredVotes: voting.RequiredVotes.PanicAlert,
+		StartedAt:     now,
+		ExpiresAt:     now.Add(voteTime),
+	})
+	if err != nil {
+		return panicbot.VoteNotice{}, err
+	}
+	return c.notifyVoters(guildID, func(member panicbot.UserRoles) bool {
+		return voting.AllowedToVote.PanicAlert.Allows(member) || c.RoleRemovedCheck(guildID, member.UserID)
+	}, func(userID string) error {
+		return c.Discord.SendDMEmbed(userID, content, description, titleText, buttonLabel, voteID)
+	})
+}
+
+func (c *Container) PanicBanCallback(guildID, userID, targetUserID, reason string, days float64) (panicbot.VoteNotice, error) {
 	// TODO write logic for starting a panicban vote
 	content := fmt.Sprintf("User <@%s> has triggered a Panic Ban vote against User <@%s>", userID, targetUserID)
 	description := fmt.Sprintf("**Reason:** %s\n\n**Action Needed:** Click the Ban User button to cast your vote.\n\n**Ignore this message if you do not want to vote.**", reason)
@@ -158,288 +385,695 @@ func (c *Container) PanicBanCallback(userID, targetUserID, reason string, days f
 
 	voteID := uuid.New().String()
 
-	c.VoteTracker[voteID] = VoteData{
-		Voters:      make(map[string]bool),
-		CallingUser: userID,
-		PanicType:   PANIC_BAN_VOTE_TYPE,
-		Days:        days,
-		BanReason:   reason,
-		TargetUser:  targetUserID,
+	voting := c.voting(guildID)
+	voteTime, err := time.ParseDuration(voting.VoteTimers.PanicBanVoteTimer)
+	if err != nil {
+		c.Logger.Errorf("failed to parse ban vote duration: %s ,setting to default time of five minutes", err.Error())
+		voteTime = time.Minute * 5
 	}
-	allUsers, err := c.Discord.GetAllGuildMembers()
+	now := time.Now()
+	err = c.startVote(voteID, VoteData{
+		GuildID:       guildID,
+		Voters:        make(map[string]bool),
+		CallingUser:   userID,
+		PanicType:     PANIC_BAN_VOTE_TYPE,
+		RequiredVotes: voting.RequiredVotes.PanicBan,
+		StartedAt:     now,
+		ExpiresAt:     now.Add(voteTime),
+		Days:          days,
+		BanReason:     reason,
+		TargetUser:    targetUserID,
+	})
+	if err != nil {
+		return panicbot.VoteNotice{}, err
+	}
+	return c.notifyVoters(guildID, voting.AllowedToVote.PanicBan.Allows, func(userID string) error {
+		return c.Discord.SendDMEmbed(userID, content, description, titleText, buttonLabel, voteID)
+	})
+}
+
+// notifyVoters sends the vote to every member of the guild that may vote on it and counts the direct messages that
+// could not be delivered.
+func (c *Container) notifyVoters(guildID string, allowed func(member panicbot.UserRoles) bool, send func(userID string) error) (panicbot.VoteNotice, error) {
+	notice := panicbot.VoteNotice{}
+	allUsers, err := c.Discord.GetAllGuildMembers(guildID)
 	if err != nil {
 		c.Logger.Errorf("failed to get all guild members: %s", err.Error())
+		return notice, fmt.Errorf("failed to get the members of the server")
 	}
 	for _, v := range allUsers {
-		if hasVotePermissions(v.UserID, v.Roles, c.Config.Voting.AllowedToVote.PanicBan.Users, c.Config.Voting.AllowedToVote.PanicBan.Roles) {
-			err := c.Discord.SendDMEmbed(userID, content, description, titleText, buttonLabel, voteID)
-			if err != nil {
-				c.Logger.Errorf("failed to send embedded direct message: %s", err.Error())
-			}
+		if !allowed(v) {
+			continue
 		}
+		err := send(v.UserID)
+		if err != nil {
+			notice.Failed++
+			c.Logger.Errorf("failed to send embedded direct message: %s", err.Error())
+			continue
+		}
+		notice.Notified++
 	}
+	return notice, nil
+}
 
-	voteTime, err := time.ParseDuration(c.Config.Voting.VoteTimers.PanicBanVoteTimer)
+// guildArgs returns every configured guild with the command rules of its voting settings.
+func (c *Container) guildArgs() ([]panicbot.GuildArgs, error) {
+	guilds := make([]panicbot.GuildArgs, 0)
+	for _, guild := range c.Config.guilds() {
+		rules, err := c.voting(guild.GuildID).commandRules()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse voting rules of guild %s: %w", guild.GuildID, err)
+		}
+		guilds = append(guilds, panicbot.GuildArgs{
+			GuildID:          guild.GuildID,
+			PrimaryChannelID: guild.PrimaryChannelID,
+			Rules:            rules,
+		})
+	}
+	return guilds, nil
+}
+
+// discordArgs connects the Discord callbacks to the container. It is shared with discordtest.New so that a fake
+// Discord drives the same callbacks as the real one.
+func (c *Container) discordArgs(guilds []panicbot.GuildArgs) *panicbot.DiscordImplArgs {
+	return &panicbot.DiscordImplArgs{
+		Guilds:                guilds,
+		BotToken:              c.Config.DiscordBotToken,
+		Greeting:              c.Config.Greeting,
+		Farewell:              c.Config.Farewell,
+		Logger:                c.Logger,
+		EmbedReactionCallback: c.EmbedReactionCallback,
+		PanicAlertCallback:    c.PanicAlertCallback,
+		PanicBanCallback:      c.PanicBanCallback,
+		RoleRemovedCallback:   c.RoleRemovedCallback,
+		PanicConfigCallback:   c.PanicConfigCallback,
+		PanicConfigSettings:   guildSettingNames(),
+		PanicAuditCallback:    c.PanicAuditCallback,
+		PanicAuditEvents:      audit.Events,
+
+		PermissionDeniedCallback: c.PermissionDeniedCallback,
+	}
+}
+
+// startVote records a new vote and schedules its expiry.
+func (c *Container) startVote(voteID string, voteData VoteData) error {
+	err := c.Votes.Start(voteID, voteData)
 	if err != nil {
-		c.Logger.Errorf("failed to parse ban vote duration: %s ,setting to default time of five minutes", err.Error())
-		voteTime = time.Minute * 5
+		c.Logger.Errorf("failed to start vote %s: %s", voteID, err.Error())
+		return fmt.Errorf("failed to start the vote")
+	}
+	c.recordAudit(voteAuditEntry(audit.VoteStarted, voteID, voteData))
+	metrics.VotesStarted.WithLabelValues(voteData.PanicType).Inc()
+	c.armVoteTimer(voteID, voteData.ExpiresAt)
+	return nil
+}
+
+// armVoteTimer expires the vote once expiresAt has been reached.
+func (c *Container) armVoteTimer(voteID string, expiresAt time.Time) {
+	c.afterFunc(time.Until(expiresAt), func() {
+		c.expireVote(voteID)
+	})
+}
+
+// afterFunc calls f in its own goroutine once d has elapsed, unless stopTimers is called first.
+func (c *Container) afterFunc(d time.Duration, f func()) {
+	c.timersMu.Lock()
+	defer c.timersMu.Unlock()
+	if c.timersStopped {
+		return
+	}
+	if c.timers == nil {
+		c.timers = make(map[*time.Timer]bool)
 	}
-	go time.AfterFunc(voteTime, func() {
-		voteData, ok := c.VoteTracker[voteID]
-		if !ok {
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		c.timersMu.Lock()
+		if c.timersStopped {
+			c.timersMu.Unlock()
 			return
 		}
-		// Remove the vote from VoteTracker. The vote failed(Not enough people voted to ban.)
-		delete(c.VoteTracker, voteID)
+		delete(c.timers, timer)
+		c.runningTimers.Add(1)
+		c.timersMu.Unlock()
+		defer c.runningTimers.Done()
+		f()
+	})
+	c.timers[timer] = true
+}
 
-		member, err := c.Discord.GetGuildMemberUsername(voteData.TargetUser)
+// stopTimers stops every pending timer started with afterFunc and waits for the timer functions that are already
+// running, or until ctx is done. No timer is started afterwards.
+func (c *Container) stopTimers(ctx context.Context) {
+	c.timersMu.Lock()
+	c.timersStopped = true
+	for timer := range c.timers {
+		timer.Stop()
+	}
+	c.timers = nil
+	c.timersMu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.runningTimers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		c.Logger.Errorf("gave up waiting for running timers: %s", ctx.Err().Error())
+	}
+}
+
+// expireVote ends a vote that did not receive enough votes in time.
+func (c *Container) expireVote(voteID string) {
+	// Remove the vote from the tracker. The vote failed(Not enough people voted.)
+	voteData, ok, err := c.Votes.End(voteID)
+	if err != nil {
+		c.Logger.Errorf("failed to end vote %s: %s", voteID, err.Error())
+	}
+	if !ok {
+		return
+	}
+	c.recordAudit(voteAuditEntry(audit.VoteExpired, voteID, voteData))
+	metrics.VotesExpired.WithLabelValues(voteData.PanicType).Inc()
+
+	switch voteData.PanicType {
+	case PANIC_ALERT_VOTE_TYPE:
+		// Send message saying that the vote failed. No one is contacted.
+		c.Discord.SendChannelMessage(voteData.GuildID, "", "Vote to alert the administrators has failed. Time elapsed and not enough votes received")
+	case PANIC_BAN_VOTE_TYPE:
+		member, err := c.Discord.GetGuildMemberUsername(voteData.GuildID, voteData.TargetUser)
 		if err != nil {
 			c.Logger.Errorf("failed to get GuildMember: %s", err.Error())
 		}
 		// Send message saying that the vote failed.
-		c.Discord.SendChannelMessage("", fmt.Sprintf("Vote to ban user %s has failed. Time elapsed and not enough votes received", member))
-	})
+		c.Discord.SendChannelMessage(voteData.GuildID, "", fmt.Sprintf("Vote to ban user %s has failed. Time elapsed and not enough votes received", member))
+	}
+}
+
+// restoreVotes re-arms the timers of the votes and grace periods that were active when the bot stopped.
+func (c *Container) restoreVotes() {
+	votes := c.Votes.Votes()
+	for voteID, voteData := range votes {
+		if voteData.GuildID == "" || voteData.RequiredVotes < 1 {
+			c.restoreLegacyVote(voteID, voteData)
+		}
+		c.armVoteTimer(voteID, voteData.ExpiresAt)
+	}
+	gracePeriod := c.Votes.GracePeriods()
+	for key, t := range gracePeriod {
+		c.armGracePeriodTimer(key, t)
+	}
+	c.Logger.Infof("restored %d active votes and %d grace periods", len(votes), len(gracePeriod))
 }
 
-func (c *Container) RoleRemovedCallback(user string, role string) {
-	if !hasVotePermissions("", []string{role}, []string{}, c.Config.Voting.AllowedToVote.PanicBan.Roles) {
+// restoreLegacyVote fills in what older versions did not save with a vote. A vote saved before guilds were tracked
+// is assigned to the only configured guild, and is dropped when there is more than one guild as there is no way to
+// tell where it was started. A vote saved before RequiredVotes was recorded gets them from the current config of
+// its guild.
+func (c *Container) restoreLegacyVote(voteID string, voteData VoteData) {
+	_, _, err := c.Votes.End(voteID)
+	if err != nil {
+		c.Logger.Errorf("failed to end vote %s: %s", voteID, err.Error())
+	}
+	if voteData.GuildID == "" {
+		guildIDs := c.Discord.GuildIDs()
+		if len(guildIDs) != 1 {
+			c.Logger.Warnf("dropped vote %s, it was saved without a guild and %d guilds are configured", voteID, len(guildIDs))
+			return
+		}
+		voteData.GuildID = guildIDs[0]
+	}
+	if voteData.RequiredVotes < 1 {
+		requiredVotes := c.voting(voteData.GuildID).RequiredVotes
+		switch voteData.PanicType {
+		case PANIC_ALERT_VOTE_TYPE:
+			voteData.RequiredVotes = requiredVotes.PanicAlert
+		case PANIC_BAN_VOTE_TYPE:
+			voteData.RequiredVotes = requiredVotes.PanicBan
+		}
+	}
+	err = c.Votes.Start(voteID, voteData)
+	if err != nil {
+		c.Logger.Errorf("failed to restore vote %s: %s", voteID, err.Error())
+	}
+}
+
+// gracePeriodKey identifies the grace period of a member of a guild in the VoteManager.
+func gracePeriodKey(guildID, userID string) string {
+	return guildID + "/" + userID
+}
+
+func (c *Container) RoleRemovedCallback(guildID, user, role string) {
+	if !slice.Contains(c.voting(guildID).AllowedToVote.PanicBan.Roles, role) {
 		return
 	}
-	c.Logger.Infof("adding user %s to trace period for role %s", user, role)
+	c.Logger.Infof("adding user %s of guild %s to trace period for role %s", user, guildID, role)
 	t := time.Now()
-	c.GracePeriod[user] = t
-	time.AfterFunc(time.Minute*30, func() {
-		t2 := c.GracePeriod[user]
-		if t == t2 {
-			delete(c.GracePeriod, user)
+	key := gracePeriodKey(guildID, user)
+	err := c.Votes.StartGracePeriod(key, t)
+	if err != nil {
+		c.Logger.Errorf("failed to start grace period of user %s: %s", user, err.Error())
+	}
+	c.armGracePeriodTimer(key, t)
+}
+
+// armGracePeriodTimer ends the grace period that started at t once GRACE_PERIOD has elapsed.
+func (c *Container) armGracePeriodTimer(key string, t time.Time) {
+	c.afterFunc(time.Until(t.Add(GRACE_PERIOD)), func() {
+		_, err := c.Votes.EndGracePeriod(key, t)
+		if err != nil {
+			c.Logger.Errorf("failed to end grace period %s: %s", key, err.Error())
 		}
 	})
 }
-func (c *Container) RoleRemovedCheck(user string) bool {
-	_, ok := c.GracePeriod[user]
-	return ok
+func (c *Container) RoleRemovedCheck(guildID, user string) bool {
+	return c.Votes.InGracePeriod(gracePeriodKey(guildID, user))
 }
 
 func (c *Container) EmbedReactionCallback(userID, voteID string) {
-	voteData, ok := c.VoteTracker[voteID]
-	if !ok {
+	// Casting and checking the threshold happen in one step so that concurrent clicks cannot pass a vote twice.
+	voteData, result, err := c.Votes.Cast(voteID, userID)
+	if err != nil {
+		c.Logger.Errorf("failed to record vote: %s", err.Error())
+	}
+	switch result {
+	case VoteNotFound:
 		err := c.Discord.SendDM(userID, "Sorry, this vote has ended")
 		if err != nil {
 			c.Logger.Errorf("could not notify the user that the vote ended: %s", err.Error())
 		}
 		return
+	case VoteAlreadyCast:
+		err := c.Discord.SendDM(userID, "Sorry, you have already participated in this vote")
+		if err != nil {
+			c.Logger.Errorf("failed to send DM: %s", err.Error())
+		}
+		return
 	}
+
+	cast := voteAuditEntry(audit.VoteCast, voteID, voteData)
+	cast.Actor = userID
+	c.recordAudit(cast)
+
+	// Let the user know their vote has been counted
+	err = c.Discord.SendDM(userID, "Thank you! Your vote has been recorded.")
+	if err != nil {
+		c.Logger.Errorf("failed to send DM: %s", err.Error())
+	}
+	if result != VotePassed {
+		return
+	}
+	c.recordAudit(voteAuditEntry(audit.VotePassed, voteID, voteData))
+	metrics.VotesPassed.WithLabelValues(voteData.PanicType).Inc()
+	metrics.VoteTimeToThreshold.WithLabelValues(voteData.PanicType).Observe(time.Since(voteData.StartedAt).Seconds())
+
 	switch voteData.PanicType {
 	case PANIC_ALERT_VOTE_TYPE:
-		// TODO: Panic Alert stuff here when they click the button
-	case PANIC_BAN_VOTE_TYPE:
-		// Check to see if the voter is already in the voters array.
-		_, ok := voteData.Voters[userID]
-		if ok {
-			err := c.Discord.SendDM(userID, "Sorry, you have already participated in this vote")
-			if err != nil {
-				c.Logger.Errorf("failed to send DM: %s", err.Error())
-			}
-			return
-		}
-		// Add the user to the Voters array and let them know their vote has been counted
-		voteData.Voters[userID] = true
-		err := c.Discord.SendDM(userID, "Thank you! Your vote has been recorded.")
+		// The result is posted first as voice calls can take minutes to be answered or to run out of attempts.
+		err = c.Discord.SendChannelMessage(voteData.GuildID, "", "Panic alert vote passed. The administrators are being contacted.")
 		if err != nil {
-			c.Logger.Errorf("failed to send DM: %s", err.Error())
+			c.Logger.Errorf("failed to notify channel of vote result: %s", err.Error())
 		}
-		if len(voteData.Voters) < c.Config.Voting.RequiredVotes.PanicBan {
-			return
+		err = c.Alert(voteData.GuildID, voteData.AlertMessage)
+		if err != nil {
+			c.Logger.Errorf("failed to alert the authorities: %s", err.Error())
 		}
-		// Delete the vote tracking
-		bannedUser, err := c.Discord.GetGuildMemberUsername(voteData.TargetUser)
+	case PANIC_BAN_VOTE_TYPE:
+		bannedUser, err := c.Discord.GetGuildMemberUsername(voteData.GuildID, voteData.TargetUser)
 		if err != nil {
 			c.Logger.Errorf("could not find guild member's username %s", err.Error())
 		}
-		err = c.Discord.BanUser(voteData.TargetUser, voteData.BanReason, int(voteData.Days))
+		err = c.Discord.BanUser(voteData.GuildID, voteData.TargetUser, voteData.BanReason, int(voteData.Days))
+		ban := voteAuditEntry(audit.BanExecuted, voteID, voteData)
+		if err != nil {
+			ban.Event = audit.BanFailed
+			ban.Details["error"] = err.Error()
+		}
+		c.recordAudit(ban)
 		if err != nil {
 			c.Logger.Errorf("failed to ban user: %s", err.Error())
+			err = c.Discord.SendChannelMessage(voteData.GuildID, "", fmt.Sprintf("Vote to ban user %s passed, but the ban failed. Please ban them manually.", bannedUser))
+			if err != nil {
+				c.Logger.Errorf("failed to notify channel of vote result: %s", err.Error())
+			}
 			return
 		}
-		err = c.Alert("")
+		err = c.Discord.SendChannelMessage(voteData.GuildID, "", fmt.Sprintf("User %s has been banned. Crisis averted.", bannedUser))
 		if err != nil {
-			c.Logger.Errorf("failed to alert the authorities: %s", err.Error())
+			c.Logger.Errorf("failed to notify channel of vote result: %s", err.Error())
 		}
-		err = c.Discord.SendChannelMessage("", fmt.Sprintf("User %s has been banned. Crisis averted.", bannedUser))
+		err = c.Alert(voteData.GuildID, fmt.Sprintf("User %s has been banned by a panic ban vote. Reason: %s", bannedUser, voteData.BanReason))
 		if err != nil {
-			c.Logger.Errorf("failed to notify channel of vote result: %s", err.Error())
+			c.Logger.Errorf("failed to alert the authorities: %s", err.Error())
 		}
-		delete(c.VoteTracker, voteID)
 	default:
 		c.Logger.Errorf("Unknown panic vote type %s", voteData.PanicType)
 	}
 }
-func (c *Container) Alert(message string) error {
-	// TODO Send any DMs that need to be sent out
-	// TODO Send any Emails that need to be sent out
-	// TODO Send any Texts that need to be sent out
-	return nil
+
+// Alert contacts everyone listed in ContactOnVote of the guild. Every target is contacted concurrently and the failures of
+// all channels are returned together so that one broken channel does not prevent the others from being used.
+func (c *Container) Alert(guildID, message string) error {
+	defer c.trackAlert()()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs *multierror.Error
+	collect := func(err error) {
+		mu.Lock()
+		errs = multierror.Append(errs, err)
+		mu.Unlock()
+	}
+
+	userIDs, err := c.discordContacts(guildID)
+	if err != nil {
+		collect(fmt.Errorf("discord: %w", err))
+	}
+	for _, userID := range userIDs {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			err := c.Discord.SendDM(userID, message)
+			c.auditAlert(guildID, "discord", userID, message, err)
+			if err != nil {
+				collect(fmt.Errorf("discord: %w", err))
+			}
+		}(userID)
+	}
+
+	if c.Twilio != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collect(c.SendPhoneAlerts(guildID, message))
+		}()
+	}
+
+	if c.Email != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collect(c.SendEmail(guildID, message))
+		}()
+	}
+
+	wg.Wait()
+	return errs.ErrorOrNil()
+}
+
+// trackAlert counts an alert that is being sent until the returned function is called. Alerts sent while shutdown
+// is waiting for the others are sent without being counted.
+func (c *Container) trackAlert() (done func()) {
+	c.alertsMu.Lock()
+	defer c.alertsMu.Unlock()
+	if c.drainingAlerts {
+		return func() {}
+	}
+	c.alerts.Add(1)
+	return c.alerts.Done
 }
 
-func hasVotePermissions(userID string, userRoles []string, allowedUserIDs []string, allowedUserRoles []string) bool {
-	if slice.Contains(allowedUserIDs, userID) {
-		return true
+// discordContacts returns the IDs of the users in ContactOnVote.Discord.Users as well as every guild member
+// holding one of the ContactOnVote.Discord.Roles.
+func (c *Container) discordContacts(guildID string) ([]string, error) {
+	contacts := c.voting(guildID).ContactOnVote.Discord
+	seen := make(map[string]struct{})
+	userIDs := make([]string, 0)
+	add := func(userID string) {
+		if userID == "" {
+			return
+		}
+		if _, ok := seen[userID]; ok {
+			return
+		}
+		seen[userID] = struct{}{}
+		userIDs = append(userIDs, userID)
+	}
+	for _, userID := range contacts.Users {
+		add(userID)
+	}
+	if len(contacts.Roles) == 0 {
+		return userIDs, nil
+	}
+	allUsers, err := c.Discord.GetAllGuildMembers(guildID)
+	if err != nil {
+		return userIDs, fmt.Errorf("failed to get all guild members: %w", err)
 	}
-	for _, userRole := range userRoles {
-		if slice.Contains(allowedUserRoles, userRole) {
-			return true
+	contactRoles := panicbot.PermissionRule{Roles: contacts.Roles}
+	for _, v := range allUsers {
+		if contactRoles.Allows(v) {
+			add(v.UserID)
 		}
 	}
-	return false
+	return userIDs, nil
 }
 
 func main() {
-	c := &Container{
-		VoteTracker: make(map[string]VoteData),
-		GracePeriod: make(map[string]time.Time),
-	}
+	c := &Container{}
 	c.configureLogger()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(c.validateConfigCommand(os.Args[2:]))
+		case "commands":
+			os.Exit(c.commandsCommand(os.Args[2:]))
+		default:
+			c.Logger.Fatalf("unknown command %s, available commands: validate-config, commands purge", os.Args[1])
+		}
+	}
 	err := c.configChanged(true)
 	if err != nil {
 		c.Logger.Fatalf("failed to load config: %s", err.Error())
 	}
-	err = c.startReloadRolesTimer()
+	c.Store, err = NewVoteStore(c.Config.Storage.Path)
+	if err != nil {
+		c.Logger.Fatalf("failed to open vote store: %s", err.Error())
+	}
+	defer c.Store.Close()
+	c.Votes, err = NewVoteManager(c.Store)
+	if err != nil {
+		c.Logger.Fatalf("failed to load active votes: %s", err.Error())
+	}
+	c.Audit, err = audit.Open(c.Config.Audit.Path, MAX_AUDIT_ENTRIES)
+	if err != nil {
+		c.Logger.Fatalf("failed to open audit log: %s", err.Error())
+	}
+	defer c.Audit.Close()
+	err = c.loadGuildSettings()
+	if err != nil {
+		c.Logger.Fatalf("failed to load guild settings: %s", err.Error())
+	}
+	stopReloadRoles, err := c.startReloadRolesTimer()
 	if err != nil {
 		c.Logger.Fatalf("failed to start timer to check for update roles : %s", err.Error())
 	}
-	c.Discord, err = panicbot.NewDiscord(&panicbot.DiscordImplArgs{
-		AllowedToVote:         c.Config.Voting.AllowedToVote,
-		BotToken:              c.Config.DiscordBotToken,
-		GuildID:               c.Config.GuildID,
-		PrimaryChannelID:      c.Config.PrimaryChannelID,
-		Logger:                c.Logger,
-		EmbedReactionCallback: c.EmbedReactionCallback,
-		PanicAlertCallback:    c.PanicAlertCallback,
-		PanicBanCallback:      c.PanicBanCallback,
-		RoleRemovedCallback:   c.RoleRemovedCallback,
-	})
-
+	c.tickers = append(c.tickers, stopReloadRoles)
+	guilds, err := c.guildArgs()
 	if err != nil {
-		c.Logger.Fatalf("failed to create Discord session: %s", err)
+		c.Logger.Fatalf("%s", err.Error())
+	}
+	metricsServer := c.startMetricsServer()
+	if metricsServer != nil {
+		defer metricsServer.Close()
 	}
-	c.Twilio, err = panicbot.NewTwilio(&panicbot.TwilioImplArgs{
-		AccountSID:        c.Config.AlertingMethods.Twilio.AccountSID,
-		APIKey:            c.Config.AlertingMethods.Twilio.APIKey,
-		APISecret:         c.Config.AlertingMethods.Twilio.APISecret,
-		TwilioPhoneNumber: c.Config.AlertingMethods.Twilio.TwilioPhoneNumber,
-		Logger:            c.Logger,
-	})
 
+	retryBackoff, err := parseOptionalDuration(c.Config.AlertingMethods.Twilio.RetryBackoff)
 	if err != nil {
-		c.Logger.Fatalf("failed to create Discord session: %s", err)
+		c.Logger.Fatalf("failed to parse Twilio RetryBackoff: %s", err.Error())
 	}
-	c.Twilio, err = panicbot.NewTwilio(&panicbot.TwilioImplArgs{
-		AccountSID:        c.Config.AlertingMethods.Twilio.AccountSID,
-		APIKey:            c.Config.AlertingMethods.Twilio.APIKey,
-		APISecret:         c.Config.AlertingMethods.Twilio.APISecret,
-		TwilioPhoneNumber: c.Config.AlertingMethods.Twilio.TwilioPhoneNumber,
-		Logger:            c.Logger,
+	twilioImpl, err := panicbot.NewTwilio(&panicbot.TwilioImplArgs{
+		AccountSID:              c.Config.AlertingMethods.Twilio.AccountSID,
+		APIKey:                  c.Config.AlertingMethods.Twilio.APIKey,
+		APISecret:               c.Config.AlertingMethods.Twilio.APISecret,
+		TwilioPhoneNumber:       c.Config.AlertingMethods.Twilio.TwilioPhoneNumber,
+		CallAttempts:            c.Config.AlertingMethods.Twilio.CallAttempts,
+		AuthToken:               c.Config.AlertingMethods.Twilio.AuthToken,
+		StatusCallbackURL:       c.Config.AlertingMethods.Twilio.StatusCallbackURL,
+		MaxRetries:              c.Config.AlertingMethods.Twilio.MaxRetries,
+		RetryBackoff:            retryBackoff,
+		DeliveryFailureCallback: c.DeliveryFailureCallback,
+		Logger:                  c.Logger,
 	})
 
 	if err != nil {
 		c.Logger.Fatalf("failed to create Twilio Rest Client: %s", err)
 	}
+	c.Twilio = twilioImpl
+
+	// Email alerting is optional, only set it up when an SMTP server has been configured.
+	if c.Config.AlertingMethods.Email.Auth.Host != "" {
+		c.Email, err = panicbot.NewEmail(&panicbot.EmailImplArgs{
+			Identity: c.Config.AlertingMethods.Email.Auth.Identity,
+			Username: c.Config.AlertingMethods.Email.Auth.Username,
+			Password: c.Config.AlertingMethods.Email.Auth.Password,
+			Host:     c.Config.AlertingMethods.Email.Auth.Host,
+			From:     c.Config.AlertingMethods.Email.From,
+			Security: c.Config.AlertingMethods.Email.Security,
+			Logger:   c.Logger,
+		})
+		if err != nil {
+			c.Logger.Fatalf("failed to create Email client: %s", err)
+		}
+	} else {
+		c.Logger.Infof("no SMTP host configured, email alerts are disabled")
+	}
+
+	c.tickers = append(c.tickers, c.checkCredentials())
+
+	// The HTTP server is started before connecting to Discord so that /readyz can report a bot that fails to connect.
+	c.readiness.setResult("discord", fmt.Errorf("not connected to Discord yet"))
+	server, err := c.startServer(twilioImpl)
+	if err != nil {
+		c.Logger.Fatalf("failed to start HTTP server: %s", err.Error())
+	}
+	if server != nil {
+		defer server.Close()
+	}
 
-	// err = c.watchFile("./config.yml")
-	// if err != nil {
-	// 	c.Logger.Fatalf("failed to watch configuration file: %s", err.Error())
-	// }
-	// Without the session I can't call Close()
-	// defer c.Discord.Close()
+	discordImpl, err := panicbot.NewDiscord(c.discordArgs(guilds))
+	if err != nil {
+		c.Logger.Fatalf("failed to create Discord session: %s", err)
+	}
+	c.Discord = discordImpl
+	err = c.Discord.Start(context.Background())
+
+	if err != nil {
+		c.Logger.Fatalf("failed to connect to Discord: %s", err)
+	}
+	c.readiness.set("discord", c.Discord.Ready)
+	c.restoreVotes()
+	stopWatching, err := c.watchFile(c.configPath())
+	if err != nil {
+		c.Logger.Fatalf("failed to watch configuration file: %s", err.Error())
+	}
+	defer stopWatching()
 
 	// pp.Println(c.Config)
 
-	c.Logger.Debugf("create channel to listen for os interrupt")
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	c.Logger.Debugf("create channel to listen for os signals")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	c.Logger.Infof("Press Ctrl+C to exit")
-	<-stop
-
-	c.Logger.Infof("Gracefully shutting down.")
-	c.Discord.SendChannelMessage("", "So long!")
+	sig := <-signals
+	for sig == syscall.SIGHUP {
+		c.Logger.Infof("received SIGHUP, reloading config")
+		err := c.configChanged(false)
+		if err != nil {
+			c.Logger.Errorf("failed to update config, keeping the previous config: %s", err.Error())
+		}
+		sig = <-signals
+	}
 
+	c.Logger.Infof("received %s, gracefully shutting down.", sig)
+	shutdownTimeout, _ := parseOptionalDuration(c.Config.ShutdownTimeout)
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = time.Second * 30
+	}
+	c.shutdown(shutdownTimeout)
 }
 
-func (c *Container) configChanged(load bool) error {
+// configPath returns the location of the config file, taken from the CONFIG environment variable.
+func (c *Container) configPath() string {
 	configFile := os.Getenv("CONFIG")
 	if configFile == "" {
 		configFile = "./config.yml"
 		c.Logger.Infof("environment variable CONFIG was empty. Setting to default config file path location: %s", configFile)
 	}
-	yfile, err := os.ReadFile(configFile)
+	return configFile
+}
+
+// readConfig reads the config file and applies the environment overrides. The config is not validated.
+func (c *Container) readConfig() (*Config, error) {
+	yfile, err := os.ReadFile(c.configPath())
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	conf := new(Config)
 
 	err = yaml.Unmarshal(yfile, conf)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal config data: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal config data: %w", err)
+	}
+	err = applyEnvOverrides(conf, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	}
+	return conf, nil
+}
+
+func (c *Container) configChanged(load bool) error {
+	conf, err := c.readConfig()
+	if err != nil {
+		return err
+	}
+	c.logEffectiveConfig(*conf)
+	if load {
+		err = c.loadConfig(*conf)
+	} else {
+		err = c.reloadConfig(*conf)
 	}
-	// if load {
-	err = c.loadConfig(*conf)
-	// } else {
-	// 	err = c.reloadConfig(*conf)
-	// }
 	if err != nil {
 		return fmt.Errorf("failed to reload config: %w", err)
 	}
 	return nil
 }
 
-// func (c *Container) watchFile(filePath string) error {
-// 	// Code taken from: https://levelup.gitconnected.com/how-to-watch-for-file-change-in-golang-4d1eaa3d2964
-// 	// Create a new file watcher.
-// 	watcher, err := fsnotify.NewWatcher()
-// 	if err != nil {
-// 		return fmt.Errorf("failed to create config filewatcher: %w", err)
-// 	}
-// 	defer watcher.Close()
-// 	_, err = os.Stat(filePath)
-// 	if os.IsNotExist(err) {
-// 		file, err := os.Create(filePath)
-// 		if err != nil {
-// 			return fmt.Errorf("failed to create file at filePath (%s) for filewatcher: %w", filePath, err)
-// 		}
-// 		file.Close()
-// 	} else if err != nil {
-// 		return fmt.Errorf("failed to stat file at filePath (%s) for filewatcher: %w", filePath, err)
-// 	}
-// 	err = watcher.Add(filePath)
-// 	if err != nil {
-// 		return fmt.Errorf("failed to add filePath (%s) to filewatcher: %w", filePath, err)
-// 	}
-
-// 	for {
-// 		select {
-// 		case event, ok := <-watcher.Events:
-// 			if !ok {
-// 				return fmt.Errorf("filewatcher events channel closed")
-// 			}
-// 			log.WithFields(log.Fields{
-// 				"Name":      event.Name,
-// 				"Operation": event.Op.String(),
-// 			}).Debug("File event occurred")
-// 			if event.Op == fsnotify.Write {
-// 				err = c.configChanged(false)
-// 				if err != nil {
-// 					return fmt.Errorf("failed to update config: %w", err)
-// 				}
-// 			}
-// 		case err, ok := <-watcher.Errors:
-// 			if !ok {
-// 				return fmt.Errorf("filewatcher errors channel closed")
-// 			}
-// 			return fmt.Errorf("filewatcher error encountered: %w", err)
-// 		}
-// 	}
-// }
+// watchFile reloads the config whenever the file at filePath changes. The directory is watched instead of the file
+// itself because editors and Kubernetes ConfigMaps replace the file rather than writing to it.
+func (c *Container) watchFile(filePath string) (stop func(), err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create config filewatcher: %w", err)
+	}
+	dir := filepath.Dir(filePath)
+	err = watcher.Add(dir)
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to add directory (%s) to filewatcher: %w", dir, err)
+	}
+
+	go func() {
+		// Saving a file often produces several events, wait for them to settle before reloading.
+		var debounce *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				c.Logger.WithFields(log.Fields{
+					"Name":      event.Name,
+					"Operation": event.Op.String(),
+				}).Debug("File event occurred")
+				// ConfigMaps swap the ..data symlink instead of touching the file.
+				name := filepath.Base(event.Name)
+				if name != filepath.Base(filePath) && name != "..data" {
+					continue
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+					continue
+				}
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(time.Millisecond*500, func() {
+					err := c.configChanged(false)
+					if err != nil {
+						c.Logger.Errorf("failed to update config, keeping the previous config: %s", err.Error())
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.Logger.Errorf("filewatcher error encountered: %s", err.Error())
+			}
+		}
+	}()
+	c.Logger.Infof("watching %s for config changes", filePath)
+	return func() { watcher.Close() }, nil
+}
 
 func (c *Container) configureLogger() {
 	c.Logger = log.StandardLogger()
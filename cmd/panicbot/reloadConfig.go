@@ -2,28 +2,189 @@ package main
 
 import (
 	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/internal/multierror"
 )
 
-// func (c *Container) reloadConfig(newConfig Config) (err error) {
-// 	oldConfig := c.Config
-// 	if oldConfig.DiscordBotToken != newConfig.DiscordBotToken {
-// 		c.Logger.Errorf("DiscordBotToken changed. User must restart this program.")
-// 	}
-// 	// TODO Check for role changing
-// 	err = c.reloadRoles()
-// 	if err != nil {
-// 		c.Logger.Fatalf("failed to load users in roles: %s", err.Error())
-// 	}
+// reloadConfig applies a changed config while the bot is running. Only the Voting sections of the guilds are
+// swapped in, settings that are used to set up connections are kept and require a restart. Votes that are in
+// progress keep the rules they were started with.
+func (c *Container) reloadConfig(newConfig Config) (err error) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	err = newConfig.check()
+	if err != nil {
+		return err
+	}
+
+	oldConfig := c.Config
+	restartRequired := []struct {
+		name    string
+		changed bool
+	}{
+		{name: "DiscordBotToken", changed: oldConfig.DiscordBotToken != newConfig.DiscordBotToken},
+		{name: "AlertingMethods", changed: !reflect.DeepEqual(oldConfig.AlertingMethods, newConfig.AlertingMethods)},
+		{name: "Server", changed: oldConfig.Server != newConfig.Server},
+		{name: "Metrics", changed: oldConfig.Metrics != newConfig.Metrics},
+		{name: "Storage", changed: oldConfig.Storage != newConfig.Storage},
+		{name: "Audit", changed: oldConfig.Audit != newConfig.Audit},
+		{name: "ShutdownTimeout", changed: oldConfig.ShutdownTimeout != newConfig.ShutdownTimeout},
+		{name: "Greeting", changed: oldConfig.Greeting != newConfig.Greeting},
+		{name: "Farewell", changed: oldConfig.Farewell != newConfig.Farewell},
+	}
+	for _, setting := range restartRequired {
+		if setting.changed {
+			c.Logger.Errorf("%s changed. The change is ignored until panicbot is restarted.", setting.name)
+		}
+	}
 
-// 	return nil
-// }
+	c.configMu.Lock()
+	// Only the guilds are swapped in, every other setting keeps its old value until the restart.
+	c.Config.GuildID = newConfig.GuildID
+	c.Config.PrimaryChannelID = newConfig.PrimaryChannelID
+	c.Config.Voting = newConfig.Voting
+	c.Config.Guilds = newConfig.Guilds
+	guilds := make(map[string]GuildConfig, len(c.guilds))
+	for guildID, guild := range c.guilds {
+		guilds[guildID] = guild
+	}
+	for _, guild := range newConfig.guilds() {
+		current, ok := guilds[guild.GuildID]
+		if !ok {
+			c.Logger.Errorf("guild %s was added. It is ignored until panicbot is restarted.", guild.GuildID)
+			continue
+		}
+		if current.PrimaryChannelID != guild.PrimaryChannelID {
+			c.Logger.Errorf("PrimaryChannelID of guild %s changed. The change is ignored until panicbot is restarted.", guild.GuildID)
+		}
+		current.Voting = c.effectiveVoting(guild.GuildID, guild.Voting, c.settings[guild.GuildID])
+		guilds[guild.GuildID] = current
+	}
+	c.guilds = guilds
+	c.configMu.Unlock()
+
+	reloaded := make(map[string]bool)
+	for _, guild := range newConfig.guilds() {
+		reloaded[guild.GuildID] = true
+	}
+	for guildID, guild := range guilds {
+		if !reloaded[guildID] {
+			c.Logger.Errorf("guild %s was removed. It keeps being served until panicbot is restarted.", guildID)
+			continue
+		}
+		rules, err := guild.Voting.commandRules()
+		if err != nil {
+			c.Logger.Errorf("failed to parse voting rules of guild %s: %s", guildID, err.Error())
+			continue
+		}
+		err = c.Discord.UpdateCommandRules(guildID, rules)
+		if err != nil {
+			c.Logger.Errorf("failed to update command rules: %s", err.Error())
+		}
+	}
+
+	c.Logger.Infof("reloaded voting config")
+	return nil
+}
 
 func (c *Container) loadConfig(newConfig Config) (err error) {
 	c.Logger.Debugf("begin loading config")
+	err = newConfig.check()
+	if err != nil {
+		return err
+	}
 	c.Config = newConfig
-
-	if c.Config.DiscordBotToken == "" {
-		return fmt.Errorf("DiscordBotToken cannot be empty, did you forget to set it in the config?")
+	c.guilds = make(map[string]GuildConfig)
+	for _, guild := range newConfig.guilds() {
+		c.guilds[guild.GuildID] = guild
 	}
 	return nil
 }
+
+// voting returns the Voting section of the guild that is currently in use. Unknown guilds get an empty Voting,
+// which allows no one to vote and contacts no one.
+func (c *Container) voting(guildID string) Voting {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.guilds[guildID].Voting
+}
+
+// check validates the config, every problem that was found is returned in a single error.
+func (conf Config) check() error {
+	var errs *multierror.Error
+	for _, problem := range conf.validate() {
+		errs = multierror.Append(errs, problem)
+	}
+	return errs.ErrorOrNil()
+}
+
+// commandRules builds the rules DiscordImpl uses to decide who may use the panic commands.
+func (v Voting) commandRules() (panicbot.CommandRules, error) {
+	cooldown, err := v.cooldown()
+	if err != nil {
+		return panicbot.CommandRules{}, err
+	}
+	abuseTracking, err := v.abuseTracking()
+	if err != nil {
+		return panicbot.CommandRules{}, err
+	}
+	return panicbot.CommandRules{
+		AllowedToVote: v.AllowedToVote,
+		Cooldown:      cooldown,
+		RateLimit:     panicbot.RateLimit(v.RateLimit),
+		AbuseTracking: abuseTracking,
+	}, nil
+}
+
+// cooldown parses the Cooldown section of the config. Empty values and -1 disable the cooldown.
+func (v Voting) cooldown() (panicbot.Cooldown, error) {
+	alert, err := parseCooldown(v.Cooldown.PanicAlert)
+	if err != nil {
+		return panicbot.Cooldown{}, fmt.Errorf("failed to parse Cooldown.PanicAlert: %w", err)
+	}
+	ban, err := parseCooldown(v.Cooldown.PanicBan)
+	if err != nil {
+		return panicbot.Cooldown{}, fmt.Errorf("failed to parse Cooldown.PanicBan: %w", err)
+	}
+	return panicbot.Cooldown{PanicAlert: alert, PanicBan: ban}, nil
+}
+
+func parseCooldown(value string) (time.Duration, error) {
+	if value == "-1" {
+		return 0, nil
+	}
+	return parseOptionalDuration(value)
+}
+
+// parseOptionalDuration parses value, returning zero when it is empty.
+func parseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// abuseTracking parses the UnauthorizedUse section of the config.
+func (v Voting) abuseTracking() (panicbot.AbuseTracking, error) {
+	tracking := panicbot.AbuseTracking{
+		Threshold:       v.UnauthorizedUse.Threshold,
+		StartPanicAlert: v.UnauthorizedUse.StartPanicAlert,
+	}
+	if tracking.Threshold < 1 {
+		return tracking, nil
+	}
+	var err error
+	tracking.Window, err = time.ParseDuration(v.UnauthorizedUse.Window)
+	if err != nil {
+		return panicbot.AbuseTracking{}, fmt.Errorf("failed to parse UnauthorizedUse.Window: %w", err)
+	}
+	tracking.Backoff, err = time.ParseDuration(v.UnauthorizedUse.Backoff)
+	if err != nil {
+		return panicbot.AbuseTracking{}, fmt.Errorf("failed to parse UnauthorizedUse.Backoff: %w", err)
+	}
+	return tracking, nil
+}
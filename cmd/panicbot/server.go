@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/internal/metrics"
+)
+
+// startServer starts the HTTP server that receives webhooks and serves /healthz and /readyz. It returns nil if no
+// ListenAddress was configured.
+func (c *Container) startServer(twilioImpl *panicbot.TwilioImpl) (*http.Server, error) {
+	if c.Config.Server.ListenAddress == "" {
+		c.Logger.Infof("no server ListenAddress configured, HTTP server is disabled")
+		return nil, nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", c.handleHealthz)
+	mux.HandleFunc("/readyz", c.handleReadyz)
+
+	if c.Config.AlertingMethods.Twilio.StatusCallbackURL != "" {
+		callbackURL, err := url.Parse(c.Config.AlertingMethods.Twilio.StatusCallbackURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Twilio StatusCallbackURL: %w", err)
+		}
+		path := callbackURL.Path
+		if path == "" {
+			path = "/"
+		}
+		mux.Handle(path, twilioImpl.StatusCallbackHandler())
+		c.Logger.Infof("receiving Twilio status callbacks on %s", path)
+	}
+
+	server := &http.Server{
+		Addr:              c.Config.Server.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	go func() {
+		c.Logger.Infof("starting HTTP server on %s", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.Logger.Errorf("HTTP server stopped: %s", err.Error())
+		}
+	}()
+	return server, nil
+}
+
+// startMetricsServer serves the Prometheus metrics on their own address so that they are not exposed together with
+// the webhooks. It returns nil if no Metrics ListenAddress was configured.
+func (c *Container) startMetricsServer() *http.Server {
+	if c.Config.Metrics.ListenAddress == "" {
+		c.Logger.Infof("no Metrics ListenAddress configured, metrics are not served")
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	server := &http.Server{
+		Addr:              c.Config.Metrics.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	go func() {
+		c.Logger.Infof("serving metrics on %s/metrics", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.Logger.Errorf("metrics server stopped: %s", err.Error())
+		}
+	}()
+	return server
+}
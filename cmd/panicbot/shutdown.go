@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// shutdown stops the bot in order: new slash commands are turned down, open votes are told that the bot is going
+// down, alerts that are being sent are given time to finish, the guilds are told farewell, the Discord session is
+// closed and the tickers are cancelled. The vote expiry and grace period timers are stopped first so that none of
+// them writes to the store once it is closed, a restarted bot arms them again from the store. shutdown returns once every step is done or timeout has passed, whichever comes first.
+func (c *Container) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c.Discord.StopAcceptingCommands()
+	c.stopTimers(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.notifyOpenVotes()
+		c.flushAlerts(ctx)
+
+		err := c.Discord.Stop(ctx)
+		if err != nil {
+			c.Logger.Errorf("failed to stop Discord: %s", err.Error())
+		}
+		err = c.Discord.Close()
+		if err != nil {
+			c.Logger.Errorf("failed to close Discord: %s", err.Error())
+		}
+		for _, stop := range c.tickers {
+			stop()
+		}
+	}()
+
+	select {
+	case <-done:
+		c.Logger.Infof("shutdown complete")
+	case <-ctx.Done():
+		c.Logger.Errorf("shutdown did not finish within %s, exiting anyway", timeout)
+	}
+}
+
+// notifyOpenVotes lets the members of the votes that are still in progress know what happens to them. Panic alert
+// votes are announced in the primary channel. Panic ban votes are only sent to the member that started the vote and
+// its voters, so that the target does not learn about the vote.
+func (c *Container) notifyOpenVotes() {
+	outcome := "has been cancelled"
+	if c.Config.Storage.Path != "" {
+		outcome = "will continue once panicbot is back"
+	}
+	for _, voteData := range c.Votes.Votes() {
+		if voteData.PanicType != PANIC_BAN_VOTE_TYPE {
+			err := c.Discord.SendChannelMessage(voteData.GuildID, "", fmt.Sprintf("Panicbot is shutting down. The panic alert vote started by <@%s> %s.", voteData.CallingUser, outcome))
+			if err != nil {
+				c.Logger.Errorf("failed to notify open vote: %s", err.Error())
+			}
+			continue
+		}
+		message := fmt.Sprintf("Panicbot is shutting down. The panic ban vote started by <@%s> %s.", voteData.CallingUser, outcome)
+		userIDs := []string{voteData.CallingUser}
+		for userID := range voteData.Voters {
+			if userID != voteData.CallingUser {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		for _, userID := range userIDs {
+			err := c.Discord.SendDM(userID, message)
+			if err != nil {
+				c.Logger.Errorf("failed to notify user %s of open vote: %s", userID, err.Error())
+			}
+		}
+	}
+}
+
+// flushAlerts waits for the alerts that are still being sent, or until ctx is done. Button clicks are still handled
+// while the bot shuts down, alerts started from now on are not waited for.
+func (c *Container) flushAlerts(ctx context.Context) {
+	c.alertsMu.Lock()
+	c.drainingAlerts = true
+	c.alertsMu.Unlock()
+
+	flushed := make(chan struct{})
+	go func() {
+		c.alerts.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		c.Logger.Errorf("gave up waiting for pending alerts: %s", ctx.Err().Error())
+	}
+}
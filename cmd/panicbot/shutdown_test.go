@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownStopsVoteTimers(t *testing.T) {
+	c, fake := newTestContainer(t, "50ms")
+
+	_, err := fake.PanicBan(testGuildID, testModIDs[0], testTargetID, "spam", 0)
+	if err != nil {
+		t.Fatalf("PanicBan failed: %s", err.Error())
+	}
+	c.shutdown(time.Second)
+	err = c.Store.Close()
+	if err != nil {
+		t.Fatalf("failed to close store: %s", err.Error())
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	if votes := c.Votes.Votes(); len(votes) != 1 {
+		t.Errorf("expected the vote to be kept for the next start instead of expiring after shutdown, got %v", votes)
+	}
+	for _, message := range fake.ChannelMessages() {
+		if strings.HasPrefix(message.Message, "Vote to ban") {
+			t.Errorf("expected the vote not to expire after shutdown, got %q", message.Message)
+		}
+	}
+
+	// Timers armed after shutdown, e.g. by a late button click, are not started either.
+	c.armVoteTimer("late", time.Now())
+	c.timersMu.Lock()
+	defer c.timersMu.Unlock()
+	if len(c.timers) != 0 {
+		t.Errorf("expected no timer to be armed after shutdown, got %d", len(c.timers))
+	}
+}
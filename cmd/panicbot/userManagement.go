@@ -6,16 +6,16 @@ import (
 	"github.com/streemtech/panicbot/ticker"
 )
 
-func (c *Container) startReloadRolesTimer() error {
+func (c *Container) startReloadRolesTimer() (cancel func(), err error) {
 	duration := time.Minute * 30
-	ticker.SimpleTickerFunc(duration, func() {
+	cancel = ticker.SimpleTickerFunc(duration, func() {
 		err := c.reloadRoles()
 		if err != nil {
 			c.Logger.Errorf("failed to reload users and roles: %s", err.Error())
 		}
 	})
 	c.Logger.Debugf("successfully started %s role reload timer", duration)
-	return nil
+	return cancel, nil
 }
 
 func (c *Container) reloadRoles() error {
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streemtech/panicbot"
+	"sigs.k8s.io/yaml"
+)
+
+// e164 matches phone numbers in the E.164 format Twilio expects, e.g. +15551234567.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// ConfigProblem is a single invalid setting, Path is its location in the YAML file.
+type ConfigProblem struct {
+	Path    string
+	Message string
+}
+
+func (p ConfigProblem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// configValidator collects every problem of a config instead of stopping at the first one.
+type configValidator struct {
+	problems []ConfigProblem
+}
+
+func (v *configValidator) add(path, format string, a ...any) {
+	v.problems = append(v.problems, ConfigProblem{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *configValidator) required(path, value string) bool {
+	if value == "" {
+		v.add(path, "cannot be empty")
+		return false
+	}
+	return true
+}
+
+// snowflake checks that value is a Discord ID. Empty values are only reported when required is set.
+func (v *configValidator) snowflake(path, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add(path, "cannot be empty")
+		}
+		return
+	}
+	_, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		v.add(path, "%q is not a Discord ID, IDs only contain digits", value)
+	}
+}
+
+func (v *configValidator) snowflakes(path string, values []string) {
+	for i, value := range values {
+		v.snowflake(fmt.Sprintf("%s[%d]", path, i), value, true)
+	}
+}
+
+func (v *configValidator) phoneNumber(path, value string) {
+	if !v.required(path, value) {
+		return
+	}
+	if !e164.MatchString(value) {
+		v.add(path, "%q is not in E.164 format, e.g. +15551234567", value)
+	}
+}
+
+func (v *configValidator) emailAddress(path, value string) {
+	if !v.required(path, value) {
+		return
+	}
+	_, err := mail.ParseAddress(value)
+	if err != nil {
+		v.add(path, "%q is not a valid email address: %s", value, err.Error())
+	}
+}
+
+func (v *configValidator) hostPort(path, value string) {
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		v.add(path, "%q is not in host:port format: %s", value, err.Error())
+		return
+	}
+	if port == "" {
+		v.add(path, "%q is missing a port", value)
+	}
+}
+
+// duration checks that value parses and is positive. Empty values are only reported when required is set.
+func (v *configValidator) duration(path, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add(path, "cannot be empty")
+		}
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.add(path, "%q is not a duration, e.g. 5m: %s", value, err.Error())
+		return
+	}
+	if d <= 0 {
+		v.add(path, "must be greater than 0, got %s", value)
+	}
+}
+
+func (v *configValidator) permissionRule(path string, rule panicbot.PermissionRule) {
+	v.snowflakes(path+".Users", rule.Users)
+	v.snowflakes(path+".Roles", rule.Roles)
+	v.snowflakes(path+".DenyUsers", rule.DenyUsers)
+	v.snowflakes(path+".DenyRoles", rule.DenyRoles)
+	if rule.RoleMatch != "" && !strings.EqualFold(rule.RoleMatch, panicbot.RoleMatchAny) && !strings.EqualFold(rule.RoleMatch, panicbot.RoleMatchAll) {
+		v.add(path+".RoleMatch", "must be either %s or %s, got %q", panicbot.RoleMatchAny, panicbot.RoleMatchAll, rule.RoleMatch)
+	}
+	for i, permission := range rule.Permissions {
+		_, err := panicbot.ParsePermissions([]string{permission})
+		if err != nil {
+			v.add(fmt.Sprintf("%s.Permissions[%d]", path, i), "%s", err.Error())
+		}
+	}
+	if len(rule.Users) == 0 && len(rule.Roles) == 0 && len(rule.Permissions) == 0 {
+		v.add(path, "does not allow anyone, set Users, Roles or Permissions")
+	}
+}
+
+// validateDiscord returns the problems of the settings needed to connect to Discord: the bot token and the IDs of
+// the guilds. Each problem is reported with its path in the YAML file.
+func (conf Config) validateDiscord() []ConfigProblem {
+	v := &configValidator{}
+	v.required("DiscordBotToken", conf.DiscordBotToken)
+	if len(conf.guilds()) == 0 {
+		v.add("GuildID", "cannot be empty, set GuildID or add an entry to Guilds")
+	} else if conf.GuildID != "" {
+		v.snowflake("GuildID", conf.GuildID, true)
+		v.snowflake("PrimaryChannelID", conf.PrimaryChannelID, false)
+	}
+	seen := make(map[string]bool)
+	if conf.GuildID != "" {
+		seen[conf.GuildID] = true
+	}
+	for i, guild := range conf.Guilds {
+		path := fmt.Sprintf("Guilds[%d]", i)
+		v.snowflake(path+".GuildID", guild.GuildID, true)
+		v.snowflake(path+".PrimaryChannelID", guild.PrimaryChannelID, false)
+		if guild.GuildID != "" && seen[guild.GuildID] {
+			v.add(path+".GuildID", "guild %s is configured more than once", guild.GuildID)
+		}
+		seen[guild.GuildID] = true
+	}
+	return v.problems
+}
+
+// validate returns every problem found in the config. Each problem is reported with its path in the YAML file.
+func (conf Config) validate() []ConfigProblem {
+	v := &configValidator{problems: conf.validateDiscord()}
+
+	v.duration("ShutdownTimeout", conf.ShutdownTimeout, false)
+	if conf.Server.ListenAddress != "" {
+		v.hostPort("Server.ListenAddress", conf.Server.ListenAddress)
+	}
+	if conf.Metrics.ListenAddress != "" {
+		v.hostPort("Metrics.ListenAddress", conf.Metrics.ListenAddress)
+		if conf.Metrics.ListenAddress == conf.Server.ListenAddress {
+			v.add("Metrics.ListenAddress", "cannot be the same as Server.ListenAddress")
+		}
+	}
+
+	twilio := conf.AlertingMethods.Twilio
+	v.required("AlertingMethods.Twilio.AccountSID", twilio.AccountSID)
+	v.required("AlertingMethods.Twilio.APIKey", twilio.APIKey)
+	v.required("AlertingMethods.Twilio.APISecret", twilio.APISecret)
+	v.phoneNumber("AlertingMethods.Twilio.TwilioPhoneNumber", twilio.TwilioPhoneNumber)
+	if twilio.CallAttempts != nil && *twilio.CallAttempts < 1 {
+		v.add("AlertingMethods.Twilio.CallAttempts", "must be at least 1")
+	}
+	if twilio.MaxRetries != nil && *twilio.MaxRetries < 0 {
+		v.add("AlertingMethods.Twilio.MaxRetries", "cannot be negative")
+	}
+	v.duration("AlertingMethods.Twilio.RetryBackoff", twilio.RetryBackoff, false)
+	if twilio.StatusCallbackURL != "" {
+		u, err := url.Parse(twilio.StatusCallbackURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			v.add("AlertingMethods.Twilio.StatusCallbackURL", "%q is not an absolute URL", twilio.StatusCallbackURL)
+		}
+		v.required("AlertingMethods.Twilio.AuthToken", twilio.AuthToken)
+	}
+
+	email := conf.AlertingMethods.Email
+	if email.Auth.Host != "" {
+		v.hostPort("AlertingMethods.Email.Auth.Host", email.Auth.Host)
+		v.emailAddress("AlertingMethods.Email.From", email.From)
+		security := strings.ToLower(email.Security)
+		if security != "" && security != panicbot.EmailSecurityStartTLS && security != panicbot.EmailSecurityTLS {
+			v.add("AlertingMethods.Email.Security", "must be either %s or %s, got %q", panicbot.EmailSecurityStartTLS, panicbot.EmailSecurityTLS, email.Security)
+		}
+	}
+
+	if conf.GuildID != "" {
+		v.voting("Voting", conf.Voting)
+	}
+	for i, guild := range conf.Guilds {
+		v.voting(fmt.Sprintf("Guilds[%d].Voting", i), guild.Voting)
+	}
+	if conf.AlertingMethods.Email.Auth.Host == "" {
+		for _, guild := range conf.guilds() {
+			if len(guild.Voting.ContactOnVote.Email.Addresses) > 0 {
+				v.add("AlertingMethods.Email.Auth.Host", "cannot be empty when ContactOnVote.Email.Addresses of guild %s is set", guild.GuildID)
+			}
+		}
+	}
+
+	return v.problems
+}
+
+// voting checks the Voting section of a guild found at path.
+func (v *configValidator) voting(path string, voting Voting) {
+	v.permissionRule(path+".AllowedToVote.PanicAlert", voting.AllowedToVote.PanicAlert)
+	v.permissionRule(path+".AllowedToVote.PanicBan", voting.AllowedToVote.PanicBan)
+	if voting.RequiredVotes.PanicAlert < 1 {
+		v.add(path+".RequiredVotes.PanicAlert", "must be at least 1, got %d", voting.RequiredVotes.PanicAlert)
+	}
+	if voting.RequiredVotes.PanicBan < 1 {
+		v.add(path+".RequiredVotes.PanicBan", "must be at least 1, got %d", voting.RequiredVotes.PanicBan)
+	}
+	v.duration(path+".VoteTimers.PanicAlertVoteTimer", voting.VoteTimers.PanicAlertVoteTimer, true)
+	v.duration(path+".VoteTimers.PanicBanVoteTimer", voting.VoteTimers.PanicBanVoteTimer, true)
+	_, err := parseCooldown(voting.Cooldown.PanicAlert)
+	if err != nil {
+		v.add(path+".Cooldown.PanicAlert", "%q is not a duration: %s", voting.Cooldown.PanicAlert, err.Error())
+	}
+	_, err = parseCooldown(voting.Cooldown.PanicBan)
+	if err != nil {
+		v.add(path+".Cooldown.PanicBan", "%q is not a duration: %s", voting.Cooldown.PanicBan, err.Error())
+	}
+
+	contacts := voting.ContactOnVote
+	v.snowflakes(path+".ContactOnVote.Discord.Users", contacts.Discord.Users)
+	v.snowflakes(path+".ContactOnVote.Discord.Roles", contacts.Discord.Roles)
+	for i, phoneNumber := range contacts.Twilio.PhoneNumbers {
+		v.phoneNumber(fmt.Sprintf("%s.ContactOnVote.Twilio.PhoneNumbers[%d]", path, i), phoneNumber)
+	}
+	phoneNumbers := make([]string, 0, len(contacts.Twilio.Methods))
+	for phoneNumber := range contacts.Twilio.Methods {
+		phoneNumbers = append(phoneNumbers, phoneNumber)
+	}
+	sort.Strings(phoneNumbers)
+	for _, phoneNumber := range phoneNumbers {
+		methodPath := fmt.Sprintf("%s.ContactOnVote.Twilio.Methods[%s]", path, phoneNumber)
+		method := strings.ToLower(contacts.Twilio.Methods[phoneNumber])
+		if method != "" && method != PHONE_METHOD_SMS && method != PHONE_METHOD_CALL && method != PHONE_METHOD_BOTH {
+			v.add(methodPath, "must be %s, %s or %s, got %q", PHONE_METHOD_SMS, PHONE_METHOD_CALL, PHONE_METHOD_BOTH, method)
+		}
+		found := false
+		for _, listed := range contacts.Twilio.PhoneNumbers {
+			if listed == phoneNumber {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.add(methodPath, "%s is not listed in %s.ContactOnVote.Twilio.PhoneNumbers", phoneNumber, path)
+		}
+	}
+	for i, address := range contacts.Email.Addresses {
+		v.emailAddress(fmt.Sprintf("%s.ContactOnVote.Email.Addresses[%d]", path, i), address)
+	}
+
+	unauthorized := voting.UnauthorizedUse
+	if unauthorized.Threshold > 0 {
+		v.duration(path+".UnauthorizedUse.Window", unauthorized.Window, true)
+		v.duration(path+".UnauthorizedUse.Backoff", unauthorized.Backoff, true)
+	}
+
+}
+
+// validateConfigCommand implements `panicbot validate-config [path]`. It prints every problem of the config file
+// and returns the exit code, which is 1 when the config is not valid.
+func (c *Container) validateConfigCommand(args []string) int {
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		path = c.configPath()
+	}
+	yfile, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config file: %s\n", err.Error())
+		return 1
+	}
+
+	problems := make([]ConfigProblem, 0)
+	// Unknown keys are usually typos, report them but keep validating the keys that are known.
+	err = yaml.UnmarshalStrict(yfile, &Config{})
+	if err != nil {
+		problems = append(problems, ConfigProblem{Path: path, Message: err.Error()})
+	}
+	conf := Config{}
+	err = yaml.Unmarshal(yfile, &conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to unmarshal config data: %s\n", err.Error())
+		return 1
+	}
+	err = applyEnvOverrides(&conf, os.LookupEnv)
+	if err != nil {
+		problems = append(problems, ConfigProblem{Path: "environment", Message: err.Error()})
+	}
+	problems = append(problems, conf.validate()...)
+
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid\n", path)
+		return 0
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem.Error())
+	}
+	fmt.Fprintf(os.Stderr, "%s has %d problem(s)\n", path, len(problems))
+	return 1
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CastResult describes what happened to a vote that was cast with VoteManager.Cast.
+type CastResult int
+
+const (
+	// VoteNotFound means the vote does not exist, usually because it already ended.
+	VoteNotFound CastResult = iota
+	// VoteAlreadyCast means the user already voted in this vote.
+	VoteAlreadyCast
+	// VoteRecorded means the vote was counted but the threshold has not been reached yet.
+	VoteRecorded
+	// VotePassed means this vote reached the threshold. The vote has been ended and no other caller will see VotePassed.
+	VotePassed
+)
+
+// VoteManager owns the active votes and grace periods. It is safe for concurrent use by the Discord handlers and
+// the timers that expire votes and grace periods. Every change is written through to the VoteStore.
+type VoteManager struct {
+	mu          sync.Mutex
+	votes       map[string]VoteData
+	gracePeriod map[string]time.Time
+	store       VoteStore
+}
+
+// NewVoteManager creates a VoteManager that starts out with the votes and grace periods found in store.
+func NewVoteManager(store VoteStore) (*VoteManager, error) {
+	if store == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	votes, err := store.LoadVotes()
+	if err != nil {
+		return nil, err
+	}
+	gracePeriod, err := store.LoadGracePeriods()
+	if err != nil {
+		return nil, err
+	}
+	return &VoteManager{
+		votes:       votes,
+		gracePeriod: gracePeriod,
+		store:       store,
+	}, nil
+}
+
+// Start adds a new vote.
+func (m *VoteManager) Start(voteID string, voteData VoteData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.votes[voteID]; ok {
+		return fmt.Errorf("vote %s already exists", voteID)
+	}
+	voteData = voteData.copy()
+	m.votes[voteID] = voteData
+	err := m.store.SaveVote(voteID, voteData)
+	if err != nil {
+		return fmt.Errorf("failed to persist vote %s: %w", voteID, err)
+	}
+	return nil
+}
+
+// Cast records the vote of userID and checks it against the RequiredVotes of the vote in one step. When the
+// threshold is reached the vote is ended and VotePassed is returned to exactly one caller. The returned VoteData is
+// a copy. A vote without RequiredVotes, such as one saved before they were recorded, never passes; the vote is
+// recorded and an error is returned instead.
+func (m *VoteManager) Cast(voteID, userID string) (VoteData, CastResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	voteData, ok := m.votes[voteID]
+	if !ok {
+		return VoteData{}, VoteNotFound, nil
+	}
+	if voteData.Voters[userID] {
+		return voteData.copy(), VoteAlreadyCast, nil
+	}
+	voteData.Voters[userID] = true
+
+	if voteData.RequiredVotes < 1 {
+		err := m.store.SaveVote(voteID, voteData)
+		if err != nil {
+			return voteData.copy(), VoteRecorded, fmt.Errorf("failed to persist vote %s: %w", voteID, err)
+		}
+		return voteData.copy(), VoteRecorded, fmt.Errorf("vote %s requires %d votes and cannot pass", voteID, voteData.RequiredVotes)
+	}
+	if len(voteData.Voters) < voteData.RequiredVotes {
+		err := m.store.SaveVote(voteID, voteData)
+		if err != nil {
+			err = fmt.Errorf("failed to persist vote %s: %w", voteID, err)
+		}
+		return voteData.copy(), VoteRecorded, err
+	}
+
+	delete(m.votes, voteID)
+	err := m.store.DeleteVote(voteID)
+	if err != nil {
+		err = fmt.Errorf("failed to remove vote %s from the store: %w", voteID, err)
+	}
+	return voteData.copy(), VotePassed, err
+}
+
+// End removes the vote and returns it. Only the first caller for a vote receives ok == true.
+func (m *VoteManager) End(voteID string) (voteData VoteData, ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	voteData, ok = m.votes[voteID]
+	if !ok {
+		return VoteData{}, false, nil
+	}
+	delete(m.votes, voteID)
+	err = m.store.DeleteVote(voteID)
+	if err != nil {
+		err = fmt.Errorf("failed to remove vote %s from the store: %w", voteID, err)
+	}
+	return voteData, true, err
+}
+
+// Votes returns a copy of every active vote.
+func (m *VoteManager) Votes() map[string]VoteData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	votes := make(map[string]VoteData, len(m.votes))
+	for voteID, voteData := range m.votes {
+		votes[voteID] = voteData.copy()
+	}
+	return votes
+}
+
+// StartGracePeriod starts, or restarts, the grace period identified by key at t. See gracePeriodKey.
+func (m *VoteManager) StartGracePeriod(key string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gracePeriod[key] = t
+	err := m.store.SaveGracePeriod(key, t)
+	if err != nil {
+		return fmt.Errorf("failed to persist grace period %s: %w", key, err)
+	}
+	return nil
+}
+
+// EndGracePeriod ends the grace period identified by key if it is still the one that started at t.
+// It returns false when the grace period was restarted in the meantime.
+func (m *VoteManager) EndGracePeriod(key string, t time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.gracePeriod[key]
+	if !ok || !current.Equal(t) {
+		return false, nil
+	}
+	delete(m.gracePeriod, key)
+	err := m.store.DeleteGracePeriod(key)
+	if err != nil {
+		return true, fmt.Errorf("failed to remove grace period %s from the store: %w", key, err)
+	}
+	return true, nil
+}
+
+// InGracePeriod reports whether the grace period identified by key is active.
+func (m *VoteManager) InGracePeriod(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.gracePeriod[key]
+	return ok
+}
+
+// GracePeriods returns a copy of every active grace period.
+func (m *VoteManager) GracePeriods() map[string]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gracePeriod := make(map[string]time.Time, len(m.gracePeriod))
+	for key, t := range m.gracePeriod {
+		gracePeriod[key] = t
+	}
+	return gracePeriod
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestVoteManager(t *testing.T) (*VoteManager, *MemoryVoteStore) {
+	t.Helper()
+	store := NewMemoryVoteStore()
+	m, err := NewVoteManager(store)
+	if err != nil {
+		t.Fatalf("failed to create vote manager: %s", err.Error())
+	}
+	return m, store
+}
+
+func startTestVote(t *testing.T, m *VoteManager, voteID string, requiredVotes int) {
+	t.Helper()
+	err := m.Start(voteID, VoteData{
+		GuildID:       "guild",
+		PanicType:     PANIC_BAN_VOTE_TYPE,
+		Voters:        make(map[string]bool),
+		RequiredVotes: requiredVotes,
+		StartedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to start vote: %s", err.Error())
+	}
+}
+
+// castConcurrently casts the votes of userIDs on voteID at the same time and counts the results.
+func castConcurrently(m *VoteManager, voteID string, userIDs []string) (map[CastResult]int, []VoteData, []error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[CastResult]int)
+	passed := make([]VoteData, 0)
+	errs := make([]error, 0)
+	start := make(chan struct{})
+	for _, userID := range userIDs {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			<-start
+			voteData, result, err := m.Cast(voteID, userID)
+			mu.Lock()
+			defer mu.Unlock()
+			results[result]++
+			if result == VotePassed {
+				passed = append(passed, voteData)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(userID)
+	}
+	close(start)
+	wg.Wait()
+	return results, passed, errs
+}
+
+func TestVoteManagerCastConcurrent(t *testing.T) {
+	const voters = 50
+	const requiredVotes = 10
+	m, store := newTestVoteManager(t)
+	startTestVote(t, m, "vote", requiredVotes)
+
+	userIDs := make([]string, voters)
+	for i := range userIDs {
+		userIDs[i] = fmt.Sprintf("user%d", i)
+	}
+	results, passed, errs := castConcurrently(m, "vote", userIDs)
+
+	if len(errs) != 0 {
+		t.Fatalf("Cast returned errors: %v", errs)
+	}
+	if results[VotePassed] != 1 {
+		t.Fatalf("expected exactly one caller to see VotePassed, got %d", results[VotePassed])
+	}
+	if results[VoteRecorded] != requiredVotes-1 {
+		t.Errorf("expected %d callers to see VoteRecorded, got %d", requiredVotes-1, results[VoteRecorded])
+	}
+	if results[VoteNotFound] != voters-requiredVotes {
+		t.Errorf("expected %d callers to see VoteNotFound, got %d", voters-requiredVotes, results[VoteNotFound])
+	}
+	if len(passed[0].Voters) != requiredVotes {
+		t.Errorf("expected the passed vote to have %d voters, got %d", requiredVotes, len(passed[0].Voters))
+	}
+	if _, ok := m.Votes()["vote"]; ok {
+		t.Errorf("expected the passed vote to be ended")
+	}
+	stored, err := store.LoadVotes()
+	if err != nil {
+		t.Fatalf("failed to load votes: %s", err.Error())
+	}
+	if _, ok := stored["vote"]; ok {
+		t.Errorf("expected the passed vote to be removed from the store")
+	}
+}
+
+func TestVoteManagerCastSameUserConcurrent(t *testing.T) {
+	m, store := newTestVoteManager(t)
+	startTestVote(t, m, "vote", 2)
+
+	userIDs := make([]string, 20)
+	for i := range userIDs {
+		userIDs[i] = "user"
+	}
+	results, _, errs := castConcurrently(m, "vote", userIDs)
+
+	if len(errs) != 0 {
+		t.Fatalf("Cast returned errors: %v", errs)
+	}
+	if results[VoteRecorded] != 1 || results[VoteAlreadyCast] != len(userIDs)-1 {
+		t.Fatalf("expected one VoteRecorded and %d VoteAlreadyCast, got %v", len(userIDs)-1, results)
+	}
+	voteData := m.Votes()["vote"]
+	if len(voteData.Voters) != 1 || !voteData.Voters["user"] {
+		t.Errorf("expected only user to have voted, got %v", voteData.Voters)
+	}
+	stored, err := store.LoadVotes()
+	if err != nil {
+		t.Fatalf("failed to load votes: %s", err.Error())
+	}
+	if len(stored["vote"].Voters) != 1 {
+		t.Errorf("expected the store to hold one voter, got %v", stored["vote"].Voters)
+	}
+}
+
+func TestVoteManagerCastWithoutRequiredVotes(t *testing.T) {
+	m, store := newTestVoteManager(t)
+	startTestVote(t, m, "vote", 0)
+
+	for _, userID := range []string{"user1", "user2", "user3"} {
+		_, result, err := m.Cast("vote", userID)
+		if result != VoteRecorded {
+			t.Fatalf("expected VoteRecorded for %s, got %d", userID, result)
+		}
+		if err == nil {
+			t.Fatalf("expected an error for a vote without RequiredVotes")
+		}
+	}
+	stored, err := store.LoadVotes()
+	if err != nil {
+		t.Fatalf("failed to load votes: %s", err.Error())
+	}
+	if len(stored["vote"].Voters) != 3 {
+		t.Errorf("expected the store to hold three voters, got %v", stored["vote"].Voters)
+	}
+}
+
+func TestVoteManagerEndOnce(t *testing.T) {
+	m, _ := newTestVoteManager(t)
+	startTestVote(t, m, "vote", 1)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ended := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok, err := m.End("vote")
+			if err != nil {
+				t.Errorf("End returned an error: %s", err.Error())
+			}
+			if ok {
+				mu.Lock()
+				ended++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ended != 1 {
+		t.Fatalf("expected exactly one caller to end the vote, got %d", ended)
+	}
+}
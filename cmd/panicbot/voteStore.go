@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	votesBucket       = []byte("votes")
+	gracePeriodBucket = []byte("gracePeriod")
+	settingsBucket    = []byte("guildSettings")
+)
+
+// VoteStore persists the active votes, grace periods and the settings changed with /panicconfig so that they
+// survive a restart of the bot.
+type VoteStore interface {
+	SaveVote(voteID string, voteData VoteData) error
+	DeleteVote(voteID string) error
+	LoadVotes() (map[string]VoteData, error)
+	SaveGracePeriod(userID string, start time.Time) error
+	DeleteGracePeriod(userID string) error
+	LoadGracePeriods() (map[string]time.Time, error)
+	// SaveGuildSettings replaces the settings of the guild, they map a setting name to its value.
+	SaveGuildSettings(guildID string, settings map[string]string) error
+	LoadGuildSettings() (map[string]map[string]string, error)
+	Close() error
+}
+
+// NewVoteStore returns a VoteStore backed by the file at path, or an in memory store if path is empty.
+func NewVoteStore(path string) (VoteStore, error) {
+	if path == "" {
+		return NewMemoryVoteStore(), nil
+	}
+	return NewBoltVoteStore(path)
+}
+
+type MemoryVoteStore struct {
+	mu          sync.Mutex
+	votes       map[string]VoteData
+	gracePeriod map[string]time.Time
+	settings    map[string]map[string]string
+}
+
+var _ VoteStore = (*MemoryVoteStore)(nil)
+
+func NewMemoryVoteStore() *MemoryVoteStore {
+	return &MemoryVoteStore{
+		votes:       make(map[string]VoteData),
+		gracePeriod: make(map[string]time.Time),
+		settings:    make(map[string]map[string]string),
+	}
+}
+
+func (m *MemoryVoteStore) SaveVote(voteID string, voteData VoteData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.votes[voteID] = voteData.copy()
+	return nil
+}
+
+func (m *MemoryVoteStore) DeleteVote(voteID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.votes, voteID)
+	return nil
+}
+
+func (m *MemoryVoteStore) LoadVotes() (map[string]VoteData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	votes := make(map[string]VoteData, len(m.votes))
+	for voteID, voteData := range m.votes {
+		votes[voteID] = voteData.copy()
+	}
+	return votes, nil
+}
+
+func (m *MemoryVoteStore) SaveGracePeriod(userID string, start time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gracePeriod[userID] = start
+	return nil
+}
+
+func (m *MemoryVoteStore) DeleteGracePeriod(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.gracePeriod, userID)
+	return nil
+}
+
+func (m *MemoryVoteStore) LoadGracePeriods() (map[string]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gracePeriod := make(map[string]time.Time, len(m.gracePeriod))
+	for userID, start := range m.gracePeriod {
+		gracePeriod[userID] = start
+	}
+	return gracePeriod, nil
+}
+
+func (m *MemoryVoteStore) SaveGuildSettings(guildID string, settings map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings[guildID] = copySettings(settings)
+	return nil
+}
+
+func (m *MemoryVoteStore) LoadGuildSettings() (map[string]map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings := make(map[string]map[string]string, len(m.settings))
+	for guildID, guildSettings := range m.settings {
+		settings[guildID] = copySettings(guildSettings)
+	}
+	return settings, nil
+}
+
+func (m *MemoryVoteStore) Close() error {
+	return nil
+}
+
+// BoltVoteStore keeps the votes in an embedded bbolt database on disk.
+type BoltVoteStore struct {
+	db *bolt.DB
+}
+
+var _ VoteStore = (*BoltVoteStore)(nil)
+
+func NewBoltVoteStore(path string) (*BoltVoteStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vote store at %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{votesBucket, gracePeriodBucket, settingsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltVoteStore{db: db}, nil
+}
+
+func (b *BoltVoteStore) SaveVote(voteID string, voteData VoteData) error {
+	return b.put(votesBucket, voteID, voteData)
+}
+
+func (b *BoltVoteStore) DeleteVote(voteID string) error {
+	return b.delete(votesBucket, voteID)
+}
+
+func (b *BoltVoteStore) LoadVotes() (map[string]VoteData, error) {
+	votes := make(map[string]VoteData)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(votesBucket).ForEach(func(k, v []byte) error {
+			voteData := VoteData{}
+			err := json.Unmarshal(v, &voteData)
+			if err != nil {
+				return fmt.Errorf("failed to decode vote %s: %w", k, err)
+			}
+			if voteData.Voters == nil {
+				voteData.Voters = make(map[string]bool)
+			}
+			votes[string(k)] = voteData
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load votes: %w", err)
+	}
+	return votes, nil
+}
+
+func (b *BoltVoteStore) SaveGracePeriod(userID string, start time.Time) error {
+	return b.put(gracePeriodBucket, userID, start)
+}
+
+func (b *BoltVoteStore) DeleteGracePeriod(userID string) error {
+	return b.delete(gracePeriodBucket, userID)
+}
+
+func (b *BoltVoteStore) LoadGracePeriods() (map[string]time.Time, error) {
+	gracePeriod := make(map[string]time.Time)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(gracePeriodBucket).ForEach(func(k, v []byte) error {
+			start := time.Time{}
+			err := json.Unmarshal(v, &start)
+			if err != nil {
+				return fmt.Errorf("failed to decode grace period of user %s: %w", k, err)
+			}
+			gracePeriod[string(k)] = start
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load grace periods: %w", err)
+	}
+	return gracePeriod, nil
+}
+
+func (b *BoltVoteStore) SaveGuildSettings(guildID string, settings map[string]string) error {
+	return b.put(settingsBucket, guildID, settings)
+}
+
+func (b *BoltVoteStore) LoadGuildSettings() (map[string]map[string]string, error) {
+	settings := make(map[string]map[string]string)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(settingsBucket).ForEach(func(k, v []byte) error {
+			guildSettings := make(map[string]string)
+			err := json.Unmarshal(v, &guildSettings)
+			if err != nil {
+				return fmt.Errorf("failed to decode settings of guild %s: %w", k, err)
+			}
+			settings[string(k)] = guildSettings
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load guild settings: %w", err)
+	}
+	return settings, nil
+}
+
+func (b *BoltVoteStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltVoteStore) put(bucket []byte, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save %s to %s: %w", key, bucket, err)
+	}
+	return nil
+}
+
+func (b *BoltVoteStore) delete(bucket []byte, key string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s from %s: %w", key, bucket, err)
+	}
+	return nil
+}
+
+func copySettings(settings map[string]string) map[string]string {
+	c := make(map[string]string, len(settings))
+	for name, value := range settings {
+		c[name] = value
+	}
+	return c
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/discordtest"
+	"github.com/streemtech/panicbot/internal/audit"
+)
+
+const (
+	testGuildID  = "111111111111111111"
+	testAdminID  = "222222222222222222"
+	testTargetID = "333333333333333333"
+	testModRole  = "444444444444444444"
+)
+
+var testModIDs = []string{"500000000000000001", "500000000000000002", "500000000000000003"}
+
+// newTestContainer returns a Container serving one guild through a discordtest.Fake. The guild has three members
+// with the mod role, which may start and vote on both panic commands, and a target without roles. Votes pass with
+// two votes and the admin is contacted by direct message once they do.
+func newTestContainer(t *testing.T, voteTimer string) (*Container, *discordtest.Fake) {
+	t.Helper()
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+
+	voting := Voting{}
+	voting.AllowedToVote.PanicAlert = panicbot.PermissionRule{Roles: []string{testModRole}}
+	voting.AllowedToVote.PanicBan = panicbot.PermissionRule{Roles: []string{testModRole}}
+	voting.RequiredVotes.PanicAlert = 2
+	voting.RequiredVotes.PanicBan = 2
+	voting.VoteTimers.PanicAlertVoteTimer = voteTimer
+	voting.VoteTimers.PanicBanVoteTimer = voteTimer
+	voting.ContactOnVote.Discord.Users = []string{testAdminID}
+
+	store := NewMemoryVoteStore()
+	votes, err := NewVoteManager(store)
+	if err != nil {
+		t.Fatalf("failed to create vote manager: %s", err.Error())
+	}
+	auditLog, err := audit.Open("", MAX_AUDIT_ENTRIES)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %s", err.Error())
+	}
+	c := &Container{
+		Config: Config{GuildID: testGuildID, Voting: voting},
+		guilds: map[string]GuildConfig{testGuildID: {GuildID: testGuildID, Voting: voting}},
+		Logger: logger,
+		Store:  store,
+		Votes:  votes,
+		Audit:  auditLog,
+	}
+	guilds, err := c.guildArgs()
+	if err != nil {
+		t.Fatalf("failed to build guild args: %s", err.Error())
+	}
+	fake, err := discordtest.New(c.discordArgs(guilds))
+	if err != nil {
+		t.Fatalf("failed to create fake Discord: %s", err.Error())
+	}
+	c.Discord = fake
+
+	members := []discordtest.Member{
+		{UserRoles: panicbot.UserRoles{UserID: testTargetID}, Username: "troll#0001"},
+		{UserRoles: panicbot.UserRoles{UserID: testAdminID}, Username: "admin#0001"},
+	}
+	for i, userID := range testModIDs {
+		members = append(members, discordtest.Member{
+			UserRoles: panicbot.UserRoles{UserID: userID, Roles: []string{testModRole}},
+			Username:  fmt.Sprintf("mod#%04d", i+1),
+		})
+	}
+	for _, member := range members {
+		err := fake.AddMember(testGuildID, member)
+		if err != nil {
+			t.Fatalf("failed to add member: %s", err.Error())
+		}
+	}
+	return c, fake
+}
+
+// voteButton returns the button ID of the vote that was sent to userID.
+func voteButton(t *testing.T, fake *discordtest.Fake, userID string) string {
+	t.Helper()
+	for _, embed := range fake.Embeds() {
+		if embed.UserID == userID {
+			return embed.ButtonID
+		}
+	}
+	t.Fatalf("no vote was sent to %s", userID)
+	return ""
+}
+
+// dmsTo returns the direct messages sent to userID.
+func dmsTo(fake *discordtest.Fake, userID string) []string {
+	messages := make([]string, 0)
+	for _, dm := range fake.DMs() {
+		if dm.UserID == userID {
+			messages = append(messages, dm.Message)
+		}
+	}
+	return messages
+}
+
+// waitFor polls condition until it is true or a second has passed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestPanicBanVotePasses(t *testing.T) {
+	c, fake := newTestContainer(t, "1m")
+
+	notice, err := fake.PanicBan(testGuildID, testModIDs[0], testTargetID, "spamming slurs", 1)
+	if err != nil {
+		t.Fatalf("PanicBan failed: %s", err.Error())
+	}
+	if notice.Notified != len(testModIDs) || notice.Failed != 0 {
+		t.Errorf("expected every mod to be notified, got %+v", notice)
+	}
+	if embeds := fake.Embeds(); len(embeds) != len(testModIDs) {
+		t.Fatalf("expected a vote to be sent to every mod, got %d", len(embeds))
+	}
+	for _, embed := range fake.Embeds() {
+		if embed.UserID == testTargetID {
+			t.Errorf("expected the target not to be sent the vote")
+		}
+	}
+	if messages := fake.ChannelMessages(); len(messages) != 0 {
+		t.Errorf("expected the vote not to be announced in the channel, got %v", messages)
+	}
+
+	voteID := voteButton(t, fake, testModIDs[0])
+	fake.ClickButton(testModIDs[0], voteID)
+	if bans := fake.Bans(); len(bans) != 0 {
+		t.Fatalf("expected no ban before the required votes were cast, got %v", bans)
+	}
+	fake.ClickButton(testModIDs[1], voteID)
+
+	bans := fake.Bans()
+	if len(bans) != 1 {
+		t.Fatalf("expected one ban, got %v", bans)
+	}
+	want := discordtest.Ban{GuildID: testGuildID, UserID: testTargetID, Reason: "spamming slurs", Days: 1}
+	if bans[0] != want {
+		t.Errorf("expected ban %+v, got %+v", want, bans[0])
+	}
+	for _, userID := range testModIDs[:2] {
+		if dms := dmsTo(fake, userID); len(dms) != 1 || dms[0] != "Thank you! Your vote has been recorded." {
+			t.Errorf("expected %s to be thanked for voting, got %v", userID, dms)
+		}
+	}
+	adminDMs := dmsTo(fake, testAdminID)
+	if len(adminDMs) != 1 || adminDMs[0] != "User troll#0001 has been banned by a panic ban vote. Reason: spamming slurs" {
+		t.Errorf("expected the admin to be alerted of the ban, got %v", adminDMs)
+	}
+	messages := fake.ChannelMessages()
+	if len(messages) != 1 || messages[0].Message != "User troll#0001 has been banned. Crisis averted." {
+		t.Errorf("expected the ban to be announced in the channel, got %v", messages)
+	}
+	if votes := c.Votes.Votes(); len(votes) != 0 {
+		t.Errorf("expected the vote to be ended, got %v", votes)
+	}
+
+	// The vote has ended, a late click must not ban again.
+	fake.ClickButton(testModIDs[2], voteID)
+	if dms := dmsTo(fake, testModIDs[2]); len(dms) != 1 || dms[0] != "Sorry, this vote has ended" {
+		t.Errorf("expected a late voter to be told that the vote ended, got %v", dms)
+	}
+	if bans := fake.Bans(); len(bans) != 1 {
+		t.Errorf("expected a single ban, got %v", bans)
+	}
+
+	events := make([]string, 0)
+	for _, entry := range c.Audit.Recent(testGuildID, "", MAX_AUDIT_ENTRIES) {
+		events = append(events, entry.Event)
+	}
+	for _, event := range []string{audit.VoteStarted, audit.VoteCast, audit.VotePassed, audit.BanExecuted, audit.AlertDispatched} {
+		found := false
+		for _, recorded := range events {
+			found = found || recorded == event
+		}
+		if !found {
+			t.Errorf("expected a %s audit entry, got %v", event, events)
+		}
+	}
+}
+
+func TestPanicBanVoteRepeatedClick(t *testing.T) {
+	_, fake := newTestContainer(t, "1m")
+
+	_, err := fake.PanicBan(testGuildID, testModIDs[0], testTargetID, "spam", 0)
+	if err != nil {
+		t.Fatalf("PanicBan failed: %s", err.Error())
+	}
+	voteID := voteButton(t, fake, testModIDs[0])
+	fake.ClickButton(testModIDs[0], voteID)
+	fake.ClickButton(testModIDs[0], voteID)
+
+	if bans := fake.Bans(); len(bans) != 0 {
+		t.Fatalf("expected one member voting twice not to pass the vote, got %v", bans)
+	}
+	dms := dmsTo(fake, testModIDs[0])
+	if len(dms) != 2 || dms[1] != "Sorry, you have already participated in this vote" {
+		t.Errorf("expected the second vote to be refused, got %v", dms)
+	}
+}
+
+func TestPanicBanDenied(t *testing.T) {
+	_, fake := newTestContainer(t, "1m")
+
+	_, err := fake.PanicBan(testGuildID, testTargetID, testModIDs[0], "revenge", 7)
+	if err == nil {
+		t.Fatalf("expected a member without the mod role to be denied")
+	}
+	if embeds := fake.Embeds(); len(embeds) != 0 {
+		t.Errorf("expected no vote to be started, got %v", embeds)
+	}
+}
+
+func TestPanicAlertVotePasses(t *testing.T) {
+	_, fake := newTestContainer(t, "1m")
+
+	_, err := fake.PanicAlert(testGuildID, testModIDs[0], "raid in #general")
+	if err != nil {
+		t.Fatalf("PanicAlert failed: %s", err.Error())
+	}
+	voteID := voteButton(t, fake, testModIDs[1])
+	fake.ClickButton(testModIDs[1], voteID)
+	fake.ClickButton(testModIDs[2], voteID)
+
+	if dms := dmsTo(fake, testAdminID); len(dms) != 1 || dms[0] != "raid in #general" {
+		t.Errorf("expected the admin to be alerted, got %v", dms)
+	}
+	messages := fake.ChannelMessages()
+	if len(messages) != 1 || !strings.HasPrefix(messages[0].Message, "Panic alert vote passed.") {
+		t.Errorf("expected the result to be announced in the channel, got %v", messages)
+	}
+}
+
+func TestPanicBanVoteExpires(t *testing.T) {
+	c, fake := newTestContainer(t, "20ms")
+
+	_, err := fake.PanicBan(testGuildID, testModIDs[0], testTargetID, "spam", 0)
+	if err != nil {
+		t.Fatalf("PanicBan failed: %s", err.Error())
+	}
+	voteID := voteButton(t, fake, testModIDs[0])
+	fake.ClickButton(testModIDs[0], voteID)
+
+	waitFor(t, "the vote to expire", func() bool {
+		return len(c.Votes.Votes()) == 0 && len(fake.ChannelMessages()) == 1
+	})
+	if message := fake.ChannelMessages()[0].Message; message != "Vote to ban user troll#0001 has failed. Time elapsed and not enough votes received" {
+		t.Errorf("unexpected expiry message: %s", message)
+	}
+
+	fake.ClickButton(testModIDs[1], voteID)
+	if dms := dmsTo(fake, testModIDs[1]); len(dms) != 1 || dms[0] != "Sorry, this vote has ended" {
+		t.Errorf("expected a vote on the expired vote to be refused, got %v", dms)
+	}
+	if bans := fake.Bans(); len(bans) != 0 {
+		t.Errorf("expected the expired vote not to ban, got %v", bans)
+	}
+	if dms := dmsTo(fake, testAdminID); len(dms) != 0 {
+		t.Errorf("expected no one to be alerted for an expired vote, got %v", dms)
+	}
+	entries := c.Audit.Recent(testGuildID, audit.VoteExpired, MAX_AUDIT_ENTRIES)
+	if len(entries) != 1 || entries[0].Details["votes"] != "1" {
+		t.Errorf("expected an expiry audit entry with one vote, got %v", entries)
+	}
+}
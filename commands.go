@@ -0,0 +1,86 @@
+package panicbot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// applicationID returns the ID of the application the slash commands belong to, which is the ID of the bot user. It
+// is looked up through the API when the session is not connected to the gateway.
+func (d *DiscordImpl) applicationID() (string, error) {
+	if d.session.State != nil && d.session.State.User != nil {
+		return d.session.State.User.ID, nil
+	}
+	user, err := d.session.User("@me")
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the bot user: %w", err)
+	}
+	return user.ID, nil
+}
+
+// syncCommands makes the slash commands of the guild match commands. The existing commands are replaced with a
+// single bulk overwrite, which creates new commands, updates changed ones and deletes every command that is not in
+// commands, so renamed and removed commands do not linger. Unchanged commands keep their IDs.
+func (d *DiscordImpl) syncCommands(guildID string, commands []*discordgo.ApplicationCommand) error {
+	appID, err := d.applicationID()
+	if err != nil {
+		return err
+	}
+	existing, err := d.session.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the slash commands of guild %s: %w", guildID, err)
+	}
+	desired := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		desired[command.Name] = true
+	}
+	obsolete := make([]string, 0)
+	for _, command := range existing {
+		if !desired[command.Name] {
+			obsolete = append(obsolete, command.Name)
+		}
+	}
+
+	_, err = d.session.ApplicationCommandBulkOverwrite(appID, guildID, commands)
+	if err != nil {
+		return fmt.Errorf("failed to overwrite the slash commands of guild %s: %w", guildID, err)
+	}
+	d.logger.WithFields(log.Fields{
+		"guildID":  guildID,
+		"commands": len(commands),
+		"removed":  obsolete,
+	}).Info("synced slash commands")
+	return nil
+}
+
+// PurgeCommands deletes every slash command of the bot from every guild, e.g. before the bot is removed from them.
+// It only needs the API, the session does not have to be started. ctx is checked between the guilds.
+func (d *DiscordImpl) PurgeCommands(ctx context.Context) error {
+	appID, err := d.applicationID()
+	if err != nil {
+		return err
+	}
+	for _, guildID := range d.guildOrder {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		existing, err := d.session.ApplicationCommands(appID, guildID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the slash commands of guild %s: %w", guildID, err)
+		}
+		for _, command := range existing {
+			err = d.session.ApplicationCommandDelete(appID, guildID, command.ID)
+			if err != nil {
+				return fmt.Errorf("failed to delete command %s from guild %s: %w", command.Name, guildID, err)
+			}
+			d.logger.WithFields(log.Fields{
+				"guildID": guildID,
+				"command": command.Name,
+			}).Info("deleted slash command")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package panicbot_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/discordtest"
+)
+
+// commandIDs returns the IDs of the slash commands of the guild by name.
+func commandIDs(server *discordtest.Server, guildID string) map[string]string {
+	ids := make(map[string]string)
+	for _, command := range server.Commands(guildID) {
+		ids[command.Name] = command.ID
+	}
+	return ids
+}
+
+func TestStartSyncsCommands(t *testing.T) {
+	server := newTestServer(t)
+	server.AddCommand(testGuildID, &discordgo.ApplicationCommand{Name: "panicvote", Description: "Renamed to /panicalert."})
+	server.AddCommand(testGuildID, &discordgo.ApplicationCommand{Name: "panicban", Description: "An outdated description."})
+	kept := commandIDs(server, testGuildID)["panicban"]
+	args := newTestDiscordArgs(server)
+	args.PanicConfigCallback = func(guildID, userID string, request panicbot.PanicConfigRequest) string { return "" }
+	startDiscord(t, args)
+
+	commands := server.Commands(testGuildID)
+	names := make([]string, 0, len(commands))
+	for _, command := range commands {
+		names = append(names, command.Name)
+	}
+	if want := []string{"panicalert", "panicban", "panicconfig"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected exactly %v to be registered, got %v", want, names)
+	}
+	for _, command := range commands {
+		if command.Name == "panicban" && (command.ID != kept || command.Description == "An outdated description.") {
+			t.Errorf("expected /panicban to be updated in place, got %+v", command)
+		}
+	}
+}
+
+func TestCommandIDsStableAcrossStarts(t *testing.T) {
+	server := newTestServer(t)
+	first := startDiscord(t, newTestDiscordArgs(server))
+	before := commandIDs(server, testGuildID)
+	err := first.Close()
+	if err != nil {
+		t.Fatalf("Close failed: %s", err.Error())
+	}
+
+	startDiscord(t, newTestDiscordArgs(server))
+	after := commandIDs(server, testGuildID)
+	if len(before) != 2 || !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the command IDs to stay the same, got %v and then %v", before, after)
+	}
+}
+
+func TestPurgeCommands(t *testing.T) {
+	server := newTestServer(t)
+	const otherGuildID = "111111111111111119"
+	server.AddGuild(otherGuildID, "other guild", 0)
+	server.AddCommand(testGuildID, &discordgo.ApplicationCommand{Name: "panicalert"})
+	server.AddCommand(testGuildID, &discordgo.ApplicationCommand{Name: "panicvote"})
+	server.AddCommand(otherGuildID, &discordgo.ApplicationCommand{Name: "panicban"})
+	args := newTestDiscordArgs(server)
+	args.Guilds = append(args.Guilds, panicbot.GuildArgs{GuildID: otherGuildID})
+	d, err := panicbot.NewDiscord(args)
+	if err != nil {
+		t.Fatalf("failed to create Discord: %s", err.Error())
+	}
+
+	// The session is never started, purging only needs the API.
+	err = d.PurgeCommands(context.Background())
+	if err != nil {
+		t.Fatalf("PurgeCommands failed: %s", err.Error())
+	}
+	for _, guildID := range []string{testGuildID, otherGuildID} {
+		if commands := server.Commands(guildID); len(commands) != 0 {
+			t.Errorf("expected no commands to be left in guild %s, got %d", guildID, len(commands))
+		}
+	}
+}
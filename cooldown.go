@@ -0,0 +1,45 @@
+package panicbot
+
+import (
+	"sync"
+	"time"
+)
+
+// Cooldown configures how long a user must wait between two uses of each panic command.
+// A duration of zero or less disables the cooldown for that command.
+type Cooldown struct {
+	PanicAlert time.Duration
+	PanicBan   time.Duration
+}
+
+// cooldownTracker remembers when each user last used each command.
+type cooldownTracker struct {
+	mu      sync.Mutex
+	lastUse map[string]time.Time
+}
+
+func newCooldownTracker() *cooldownTracker {
+	return &cooldownTracker{
+		lastUse: make(map[string]time.Time),
+	}
+}
+
+// tryStart starts the cooldown of userID for command at now, unless it is still running. It returns how long userID
+// still has to wait, or zero if the cooldown was started. Checking and starting happen under one lock so that two
+// commands used at the same time cannot both pass.
+func (t *cooldownTracker) tryStart(command, userID string, cooldown time.Duration, now time.Time) time.Duration {
+	if cooldown <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := command + ":" + userID
+	if lastUse, ok := t.lastUse[key]; ok {
+		remaining := lastUse.Add(cooldown).Sub(now)
+		if remaining > 0 {
+			return remaining
+		}
+	}
+	t.lastUse[key] = now
+	return 0
+}
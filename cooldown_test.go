@@ -0,0 +1,52 @@
+package panicbot
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCooldownTryStart(t *testing.T) {
+	c := newCooldownTracker()
+	now := time.Now()
+
+	if remaining := c.tryStart("panicban", "1", time.Minute, now); remaining != 0 {
+		t.Fatalf("expected the first use to start the cooldown, got %s remaining", remaining)
+	}
+	if remaining := c.tryStart("panicban", "1", time.Minute, now.Add(time.Second*20)); remaining != time.Second*40 {
+		t.Errorf("expected 40s remaining, got %s", remaining)
+	}
+	if remaining := c.tryStart("panicalert", "1", time.Minute, now); remaining != 0 {
+		t.Errorf("expected the cooldown of another command not to apply, got %s remaining", remaining)
+	}
+	if remaining := c.tryStart("panicban", "2", time.Minute, now); remaining != 0 {
+		t.Errorf("expected the cooldown of another user not to apply, got %s remaining", remaining)
+	}
+	if remaining := c.tryStart("panicban", "1", time.Minute, now.Add(time.Minute)); remaining != 0 {
+		t.Errorf("expected the cooldown to have run out, got %s remaining", remaining)
+	}
+	if remaining := c.tryStart("panicban", "1", 0, now.Add(time.Minute)); remaining != 0 {
+		t.Errorf("expected a disabled cooldown never to apply, got %s remaining", remaining)
+	}
+}
+
+func TestCooldownTryStartConcurrent(t *testing.T) {
+	c := newCooldownTracker()
+	now := time.Now()
+	var started int32
+	var wg sync.WaitGroup
+	for n := 0; n < 50; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.tryStart("panicban", "1", time.Minute, now) == 0 {
+				atomic.AddInt32(&started, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 1 {
+		t.Errorf("expected exactly one of the commands used at the same time to pass the cooldown, got %d", started)
+	}
+}
@@ -1,92 +1,165 @@
 package panicbot
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sync/atomic"
 	"time"
 
-	"github.com/k0kubun/pp/v3"
 	log "github.com/sirupsen/logrus"
-	"github.com/streemtech/panicbot/internal/logic"
-	"github.com/streemtech/panicbot/internal/slice"
+	"github.com/streemtech/panicbot/internal/baseurl"
+	"github.com/streemtech/panicbot/internal/metrics"
+	"github.com/streemtech/panicbot/internal/multierror"
 
 	"github.com/bwmarrin/discordgo"
 )
 
+// Discord is the connection to every guild the bot serves. Guild scoped calls take the ID of the guild they act on.
 type Discord interface {
-	BanUser(userID string, reason string, days int) error
-	SendChannelMessage(channelID string, message string) error
+	BanUser(guildID, userID string, reason string, days int) error
+	// SendChannelMessage sends message to channelID, or to the primary channel of the guild when channelID is empty.
+	SendChannelMessage(guildID, channelID string, message string) error
 	SendDMEmbed(userID, content, description, titleText, buttonLabel, buttonID string) error
 	SendDM(userID string, message string) error
-	GetAllGuildMembers() ([]UserRoles, error)
-	GetGuildMemberUsername(userID string) (string, error)
+	GetAllGuildMembers(guildID string) ([]UserRoles, error)
+	GetGuildMemberUsername(guildID, userID string) (string, error)
+	UpdateCommandRules(guildID string, rules CommandRules) error
+	GuildIDs() []string
+	// Ready returns why the bot cannot handle commands right now, or nil if it is connected to the gateway and its
+	// slash commands are registered.
+	Ready() error
+	// Start connects to Discord, Stop turns down new commands and says farewell, Close closes the connection.
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	StopAcceptingCommands()
+	Close() error
+}
+
+// VoteNotice tells the member that started a vote how many voters were sent the vote and how many direct messages
+// to voters could not be delivered.
+type VoteNotice struct {
+	Notified int
+	Failed   int
 }
 
 type UserRoles struct {
 	UserID string
 	Roles  []string
+	// Permissions are the guild wide permissions granted by the roles of the user.
+	Permissions int64
 }
 type AllowedToVote struct {
-	PanicAlert struct {
-		Users []string
-		Roles []string
-	}
-	PanicBan struct {
-		Users []string
-		Roles []string
-	}
+	PanicAlert PermissionRule
+	PanicBan   PermissionRule
 }
+
+// CommandRules decide who may use the panic commands and how often. They can be replaced while the bot is running
+// with UpdateCommandRules.
+type CommandRules struct {
+	AllowedToVote AllowedToVote
+	Cooldown      Cooldown
+	RateLimit     RateLimit
+	AbuseTracking AbuseTracking
+}
+
 type DiscordImpl struct {
-	allowedToVote         AllowedToVote
+	// closing is set to 1 once StopAcceptingCommands has been called.
+	closing int32
+	// connected is set to 1 once the first connection to the gateway was established, every following connection is
+	// counted as a reconnect.
+	connected int32
+	// gatewayUp is 1 while the session is connected to the gateway.
+	gatewayUp int32
+	// commandsRegistered is set to 1 once the slash commands were registered in every guild.
+	commandsRegistered int32
+	// started is set to 1 by Start.
+	started int32
+	// greeting and farewell are sent to the primary channel of every guild by Start and Stop, unless empty.
+	greeting string
+	farewell string
+	// guilds is not changed after NewDiscord returns, guildOrder keeps the order of the config.
+	guilds                map[string]*guild
+	guildOrder            []string
 	botToken              string
-	guildID               string
-	primaryChannelID      string
 	logger                *log.Logger
 	session               *discordgo.Session
 	embedReactionCallback func(userID, buttonID string)
-	panicAlertCallback    func(message string)
-	panicBanCallback      func(userID, targetUserID, reason string, days float64)
-	roleRemovedCallback   func(user, role string)
+	panicAlertCallback    func(guildID, userID, message string) (VoteNotice, error)
+	panicBanCallback      func(guildID, userID, targetUserID, reason string, days float64) (VoteNotice, error)
+	roleRemovedCallback   func(guildID, user, role string)
+	panicConfigCallback   func(guildID, userID string, request PanicConfigRequest) string
+	panicConfigSettings   []string
+	panicAuditCallback    func(guildID, userID string, request PanicAuditRequest) string
+	panicAuditEvents      []string
+	// permissionDeniedCallback is optional, it is called for every command a member was not allowed to use.
+	permissionDeniedCallback func(guildID, userID, command string)
 }
 
 type DiscordImplArgs struct {
-	AllowedToVote         AllowedToVote
-	BotToken              string
-	GuildID               string
-	PrimaryChannelID      string
-	Logger                *log.Logger
-	Session               *discordgo.Session
+	// Guilds are the guilds the bot serves. Slash commands are registered in each of them.
+	Guilds   []GuildArgs
+	BotToken string
+	// APIBaseURL is optional. When set every request to the Discord API is sent to it instead of https://discord.com,
+	// e.g. to the URL of a discordtest.Server. The gateway follows, its URL is looked up through the API.
+	APIBaseURL string
+	Logger     *log.Logger
+	// Session is optional. When set it is used instead of a new session created with BotToken.
+	Session *discordgo.Session
+	// Greeting and Farewell are optional messages sent to the primary channel of every guild by Start and Stop.
+	Greeting              string
+	Farewell              string
 	EmbedReactionCallback func(userID, buttonID string)
-	PanicAlertCallback    func(message string)
-	PanicBanCallback      func(userID, targetUserID, reason string, days float64)
-	RoleRemovedCallback   func(user, role string)
+	// PanicAlertCallback and PanicBanCallback start a vote. The VoteNotice, or the error if the vote could not be
+	// started or the voters could not be notified, is reported to the member that used the command. A vote that
+	// returned an error does not count against the RateLimit.
+	PanicAlertCallback  func(guildID, userID, message string) (VoteNotice, error)
+	PanicBanCallback    func(guildID, userID, targetUserID, reason string, days float64) (VoteNotice, error)
+	RoleRemovedCallback func(guildID, user, role string)
+	// PanicConfigCallback is optional. When set /panicconfig is registered, the returned text is shown to the
+	// administrator that used the command.
+	PanicConfigCallback func(guildID, userID string, request PanicConfigRequest) string
+	// PanicConfigSettings are the names of the settings that can be changed with /panicconfig.
+	PanicConfigSettings []string
+	// PanicAuditCallback is optional. When set /panicaudit is registered, the returned text is shown to the
+	// administrator that used the command.
+	PanicAuditCallback func(guildID, userID string, request PanicAuditRequest) string
+	// PanicAuditEvents are the events /panicaudit can be filtered by.
+	PanicAuditEvents         []string
+	PermissionDeniedCallback func(guildID, userID, command string)
 }
 
 var _ Discord = (*DiscordImpl)(nil)
 
-func (d *DiscordImpl) BanUser(userID string, reason string, days int) error {
+func (d *DiscordImpl) BanUser(guildID, userID string, reason string, days int) error {
 
-	err := d.session.GuildBanCreateWithReason(d.guildID, userID, reason, days)
+	err := d.session.GuildBanCreateWithReason(guildID, userID, reason, days)
 	if err != nil {
 		return fmt.Errorf("failed to ban user with userID:  %s", userID)
 	}
 
-	guildBan, err := d.session.GuildBan(d.guildID, userID)
+	guildBan, err := d.session.GuildBan(guildID, userID)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve ban information for user with userID: %s", userID)
 	}
 
 	d.logger.WithFields(log.Fields{
+		"guildID":  guildID,
 		"user":     guildBan.User.String(),
 		"reason":   reason,
 		"dateTime": time.Now().String(),
-	})
+	}).Info("Banned user")
 
 	return nil
 }
 
-func (d *DiscordImpl) SendChannelMessage(channelID string, content string) error {
+func (d *DiscordImpl) SendChannelMessage(guildID, channelID string, content string) error {
 	if channelID == "" {
-		channelID = d.primaryChannelID
+		g, err := d.guild(guildID)
+		if err != nil {
+			return err
+		}
+		channelID = g.primaryChannelID
 	}
 	message, err := d.session.ChannelMessageSend(channelID, content)
 	if err != nil {
@@ -99,13 +172,19 @@ func (d *DiscordImpl) SendChannelMessage(channelID string, content string) error
 		"message":   message.Content,
 		"messageID": message.ID,
 		"dateTime":  time.Now().String(),
-	})
+	}).Info("Sent channel message")
 	return nil
 }
 
 func (d *DiscordImpl) SendDM(userID string, message string) error {
-	err := d.SendChannelMessage(userID, message)
+	channel, err := d.session.UserChannelCreate(userID)
 	if err != nil {
+		metrics.DMFailures.WithLabelValues("message").Inc()
+		return fmt.Errorf("failed to create private message channel with userID: %s", userID)
+	}
+	_, err = d.session.ChannelMessageSend(channel.ID, message)
+	if err != nil {
+		metrics.DMFailures.WithLabelValues("message").Inc()
 		return fmt.Errorf("failed to send direct message to user with ID: %s", userID)
 	}
 	return nil
@@ -114,6 +193,7 @@ func (d *DiscordImpl) SendDM(userID string, message string) error {
 func (d *DiscordImpl) SendDMEmbed(userID, content, description, titleText, buttonLabel, buttonID string) error {
 	channel, err := d.session.UserChannelCreate(userID)
 	if err != nil {
+		metrics.DMFailures.WithLabelValues("embed").Inc()
 		return fmt.Errorf("failed to create private message channel with userID: %s", userID)
 	}
 	message := &discordgo.MessageSend{
@@ -138,6 +218,7 @@ func (d *DiscordImpl) SendDMEmbed(userID, content, description, titleText, butto
 	}
 	_, err = d.session.ChannelMessageSendComplex(channel.ID, message)
 	if err != nil {
+		metrics.DMFailures.WithLabelValues("embed").Inc()
 		return fmt.Errorf("failed to send private message with embed to user with ID: %s: %w", userID, err)
 	}
 	d.logger.WithFields(log.Fields{
@@ -146,15 +227,15 @@ func (d *DiscordImpl) SendDMEmbed(userID, content, description, titleText, butto
 	return nil
 }
 
-func (d *DiscordImpl) GetAllGuildMembers() ([]UserRoles, error) {
+func (d *DiscordImpl) GetAllGuildMembers(guildID string) ([]UserRoles, error) {
 	temp := make([]*discordgo.Member, 0)
 	userRoles := make([]UserRoles, 0)
 	latestMember := ""
 	for {
 		// Make a call to GuildMembers
-		gm, err := d.session.GuildMembers(d.guildID, latestMember, 1000)
+		gm, err := d.session.GuildMembers(guildID, latestMember, 1000)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get guild members from guild with ID: %s", d.guildID)
+			return nil, fmt.Errorf("failed to get guild members from guild with ID: %s", guildID)
 		}
 		// Append the result of the call to GuildMembers to out
 		temp = append(temp, gm...)
@@ -164,49 +245,240 @@ func (d *DiscordImpl) GetAllGuildMembers() ([]UserRoles, error) {
 		}
 		latestMember = gm[999].User.ID
 	}
+	roles, err := d.session.GuildRoles(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles from guild with ID: %s", guildID)
+	}
+	rolePermissions := make(map[string]int64, len(roles))
+	for _, role := range roles {
+		rolePermissions[role.ID] = role.Permissions
+	}
 	for _, v := range temp {
-		userRoles = append(userRoles, UserRoles{UserID: v.User.ID, Roles: v.Roles})
+		// Every member has the @everyone role, which shares its ID with the guild.
+		permissions := rolePermissions[guildID]
+		for _, role := range v.Roles {
+			permissions |= rolePermissions[role]
+		}
+		userRoles = append(userRoles, UserRoles{UserID: v.User.ID, Roles: v.Roles, Permissions: permissions})
 	}
 	return userRoles, nil
 
 }
 
-func (d *DiscordImpl) GetGuildMemberUsername(userID string) (string, error) {
+// UpdateCommandRules replaces the rules used for every following slash command in the guild.
+func (d *DiscordImpl) UpdateCommandRules(guildID string, rules CommandRules) error {
+	g, err := d.guild(guildID)
+	if err != nil {
+		return err
+	}
+	g.setCommandRules(rules)
+	return nil
+}
+
+// StopAcceptingCommands makes the bot turn down every new slash command, e.g. while it is shutting down. Votes
+// that are already in progress can still be cast.
+func (d *DiscordImpl) StopAcceptingCommands() {
+	atomic.StoreInt32(&d.closing, 1)
+}
+
+// Close closes the websocket connection to Discord.
+func (d *DiscordImpl) Close() error {
+	d.StopAcceptingCommands()
+	err := d.session.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close Discord session: %w", err)
+	}
+	d.logger.Infof("closed websocket connection to Discord")
+	return nil
+}
+
+func (d *DiscordImpl) GetGuildMemberUsername(guildID, userID string) (string, error) {
 	if userID == "" {
 		return "", fmt.Errorf("userID cannot be empty: %s", userID)
 	}
-	member, err := d.session.GuildMember(d.guildID, userID)
+	member, err := d.session.GuildMember(guildID, userID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get member username with ID: %s in guild with ID: %s", userID, d.guildID)
+		return "", fmt.Errorf("failed to get member username with ID: %s in guild with ID: %s", userID, guildID)
 	}
 	return fmt.Sprintf("%s#%s", member.User.Username, member.User.Discriminator), nil
 }
 
-func handlePermissionsBadRequest(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	// TODO 3: Track if the user without permissions is doing this multiple times and stop the bot from responding.
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+func (d *DiscordImpl) handlePermissionsBadRequest(s *discordgo.Session, i *discordgo.InteractionCreate, g *guild, command string, tracking AbuseTracking) {
+	userID := i.Member.User.ID
+	metrics.SlashCommands.WithLabelValues(command, metrics.CommandDenied).Inc()
+	if d.permissionDeniedCallback != nil {
+		d.permissionDeniedCallback(g.id, userID, command)
+	}
+	respond, report := g.abuseTracker.deny(userID, tracking, time.Now())
+	if report {
+		d.reportUnauthorizedUser(g.id, userID, command, tracking)
+	}
+	if !respond {
+		d.logger.WithFields(log.Fields{
+			"command": command,
+			"guildID": g.id,
+			"userID":  userID,
+		}).Debug("ignoring denied panic command from reported user")
+		return
+	}
+	err := respondEphemeral(s, i, "I'm sorry, you do not have permission to use this command.")
+	if err != nil {
+		d.logger.Errorf("failed to respond to application command: %s", err.Error())
+	}
+}
+
+// reportUnauthorizedUser tells the primary channel about a user that keeps using commands they are not allowed to use.
+func (d *DiscordImpl) reportUnauthorizedUser(guildID, userID, command string, tracking AbuseTracking) {
+	message := fmt.Sprintf("User <@%s> tried to use /%s without permission %d times within %s. I will ignore them for %s.", userID, command, tracking.Threshold, tracking.Window, tracking.Backoff)
+	d.logger.WithFields(log.Fields{
+		"command": command,
+		"guildID": guildID,
+		"userID":  userID,
+	}).Warn("user repeatedly used panic commands without permission")
+	err := d.SendChannelMessage(guildID, "", message)
+	if err != nil {
+		d.logger.Errorf("failed to report unauthorized user: %s", err.Error())
+	}
+	if tracking.StartPanicAlert {
+		_, err = d.panicAlertCallback(guildID, d.session.State.User.ID, message)
+		if err != nil {
+			d.logger.Errorf("failed to start panic alert vote for unauthorized user: %s", err.Error())
+		}
+	}
+}
+
+// commandOutcome returns the outcome of a command that was answered with err.
+func commandOutcome(err error) string {
+	if err != nil {
+		return metrics.CommandFailed
+	}
+	return metrics.CommandAccepted
+}
+
+// handleConnect counts every connection to the gateway after the first one as a reconnect.
+func (d *DiscordImpl) handleConnect(s *discordgo.Session, c *discordgo.Connect) {
+	atomic.StoreInt32(&d.gatewayUp, 1)
+	if atomic.CompareAndSwapInt32(&d.connected, 0, 1) {
+		return
+	}
+	metrics.GatewayReconnects.Inc()
+	d.logger.Info("reconnected to the Discord gateway")
+}
+
+func (d *DiscordImpl) handleDisconnect(s *discordgo.Session, c *discordgo.Disconnect) {
+	atomic.StoreInt32(&d.gatewayUp, 0)
+	d.logger.Warn("lost connection to the Discord gateway")
+}
+
+func (d *DiscordImpl) Ready() error {
+	if atomic.LoadInt32(&d.gatewayUp) == 0 {
+		return fmt.Errorf("not connected to the Discord gateway")
+	}
+	if atomic.LoadInt32(&d.commandsRegistered) == 0 {
+		return fmt.Errorf("slash commands are not registered")
+	}
+	return nil
+}
+
+// interactionMember returns the roles and permissions of the member that triggered the interaction.
+func interactionMember(i *discordgo.InteractionCreate) UserRoles {
+	return UserRoles{
+		UserID:      i.Member.User.ID,
+		Roles:       i.Member.Roles,
+		Permissions: i.Member.Permissions,
+	}
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "I'm sorry, you do not have permission to use this command.",
+			Content: content,
+			Flags:   uint64(discordgo.MessageFlagsEphemeral),
 		},
 	})
 }
 
-func hasCommandPermissions(userIDsAllowedToVote []string, userID string, userRolesAllowedToVote []string, userRoles []string) bool {
+// deferEphemeral acknowledges the interaction with a response that is only shown to the member that used the
+// command. The response has to be filled in with InteractionResponseEdit.
+func deferEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate) error {
+	return s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
+}
 
-	userHasPermissions := slice.Contains(userIDsAllowedToVote, userID)
-	roleHasPermissions := false
-	// Check each role a user has against the list of userRolesAllowedToVote
-	for _, v := range userRoles {
-		roleHasPermissions = slice.Contains(userRolesAllowedToVote, v)
+// reportVoteStarted fills in the deferred response of a panic command with how many voters were notified.
+func (d *DiscordImpl) reportVoteStarted(s *discordgo.Session, i *discordgo.InteractionCreate, kind string, notice VoteNotice, err error) {
+	content := fmt.Sprintf("Your %s vote has started. %d voter(s) were notified", kind, notice.Notified)
+	if notice.Failed > 0 {
+		content += fmt.Sprintf(", %d direct message(s) could not be delivered", notice.Failed)
+	}
+	content += "."
+	if err != nil {
+		d.logger.Errorf("failed to notify the voters of the %s vote: %s", kind, err.Error())
+		content = fmt.Sprintf("Your %s vote has started, but the voters could not be notified: %s", kind, err.Error())
 	}
-	return logic.Or(userHasPermissions, roleHasPermissions)
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: content})
+	if err != nil {
+		d.logger.Errorf("failed to edit response to application command: %s", err.Error())
+	}
+}
+
+// onCooldown tells the user how long they have to wait if they used command too recently. Otherwise the cooldown
+// of the user is started.
+func (d *DiscordImpl) onCooldown(s *discordgo.Session, i *discordgo.InteractionCreate, g *guild, command string, cooldown time.Duration) bool {
+	remaining := g.cooldowns.tryStart(command, i.Member.User.ID, cooldown, time.Now())
+	if remaining <= 0 {
+		return false
+	}
+	metrics.SlashCommands.WithLabelValues(command, metrics.CommandCooldown).Inc()
+	err := respondEphemeral(s, i, fmt.Sprintf("You are on cooldown. You can use /%s again in %s.", command, remaining.Round(time.Second)))
+	if err != nil {
+		d.logger.Errorf("failed to respond to application command: %s", err.Error())
+	}
+	return true
+}
+
+// rateLimited rejects the command if too many votes of this type were started in the guild recently. A vote only
+// counts against the limit once it was started.
+func (d *DiscordImpl) rateLimited(s *discordgo.Session, i *discordgo.InteractionCreate, g *guild, command string, hourLimit, dayLimit int) bool {
+	allowed, reason := g.rateLimiter.allow(command, hourLimit, dayLimit, time.Now())
+	if allowed {
+		return false
+	}
+	d.logger.WithFields(log.Fields{
+		"command": command,
+		"guildID": g.id,
+		"userID":  i.Member.User.ID,
+		"reason":  reason,
+	}).Warn("rejected panic command because of the rate limit")
+	metrics.SlashCommands.WithLabelValues(command, metrics.CommandRateLimited).Inc()
+	err := respondEphemeral(s, i, reason)
+	if err != nil {
+		d.logger.Errorf("failed to respond to application command: %s", err.Error())
+	}
+	return true
 }
 
 func NewDiscord(args *DiscordImplArgs) (*DiscordImpl, error) {
-	// Validate that Guild ID is set. If primaryChannelID is not set calculate it.
-	if args.GuildID == "" {
-		return nil, fmt.Errorf("GuildID cannot be empty. Did you forget to set it in the config? %s", args.GuildID)
+	// Validate that every Guild ID is set. If a primaryChannelID is not set calculate it.
+	if len(args.Guilds) == 0 {
+		return nil, fmt.Errorf("Guilds cannot be empty. Did you forget to set GuildID in the config?")
+	}
+	guilds := make(map[string]*guild, len(args.Guilds))
+	guildOrder := make([]string, 0, len(args.Guilds))
+	for _, guildArgs := range args.Guilds {
+		if guildArgs.GuildID == "" {
+			return nil, fmt.Errorf("GuildID cannot be empty. Did you forget to set it in the config?")
+		}
+		if _, ok := guilds[guildArgs.GuildID]; ok {
+			return nil, fmt.Errorf("guild %s is configured more than once", guildArgs.GuildID)
+		}
+		guilds[guildArgs.GuildID] = newGuild(guildArgs)
+		guildOrder = append(guildOrder, guildArgs.GuildID)
 	}
 
 	// Verify the logger is set
@@ -225,71 +497,128 @@ func NewDiscord(args *DiscordImplArgs) (*DiscordImpl, error) {
 		return nil, fmt.Errorf("failed to start bot, PanicBanCallback was not passed in")
 	}
 
-	args.Logger.Info("preparing Discord session")
-	// Initialize the bot, register the slash commands
-	session, err := discordgo.New("Bot " + args.BotToken)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare session to Discord")
+	session := args.Session
+	if session == nil {
+		args.Logger.Info("preparing Discord session")
+		var err error
+		session, err = discordgo.New("Bot " + args.BotToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare session to Discord")
+		}
+		session.StateEnabled = true
+	}
+	if args.APIBaseURL != "" {
+		transport, err := baseurl.NewTransport(args.APIBaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid APIBaseURL: %w", err)
+		}
+		session.Client = &http.Client{
+			Timeout:   session.Client.Timeout,
+			Transport: transport,
+		}
 	}
-
-	session.StateEnabled = true
 	// Create a DiscordImpl with args
 	discordImpl := &DiscordImpl{
-		allowedToVote:         args.AllowedToVote,
+		guilds:                guilds,
+		guildOrder:            guildOrder,
 		botToken:              args.BotToken,
-		guildID:               args.GuildID,
-		primaryChannelID:      args.PrimaryChannelID,
 		logger:                args.Logger,
 		embedReactionCallback: args.EmbedReactionCallback,
 		panicAlertCallback:    args.PanicAlertCallback,
 		panicBanCallback:      args.PanicBanCallback,
 		roleRemovedCallback:   args.RoleRemovedCallback,
-		session:               session,
+		panicConfigCallback:   args.PanicConfigCallback,
+		panicConfigSettings:   args.PanicConfigSettings,
+		panicAuditCallback:    args.PanicAuditCallback,
+		panicAuditEvents:      args.PanicAuditEvents,
+
+		permissionDeniedCallback: args.PermissionDeniedCallback,
+		session:                  session,
+		greeting:                 args.Greeting,
+		farewell:                 args.Farewell,
 	}
+	return discordImpl, nil
+}
 
-	if discordImpl.primaryChannelID == "" {
-		primaryChannel, err := discordImpl.findPrimaryChannelInGuild()
+// Start resolves the primary channels, connects to the gateway, registers the slash commands and greets every
+// guild. ctx is checked between the steps, a step that already started is not interrupted.
+func (d *DiscordImpl) Start(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&d.started, 0, 1) {
+		return fmt.Errorf("Discord was already started")
+	}
+	for _, g := range d.guilds {
+		// A configured primary channel is checked to exist, otherwise one is picked from the channels of the guild.
+		primaryChannel, err := d.findPrimaryChannelInGuild(g)
 		if err != nil {
-			return nil, fmt.Errorf("failed to determine primary channel in guild: %w", err)
+			return fmt.Errorf("failed to determine primary channel in guild %s: %w", g.id, err)
 		}
-		discordImpl.primaryChannelID = primaryChannel
+		g.primaryChannelID = primaryChannel
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	discordImpl.logger.Info("running bot startup")
-
-	discordImpl.logger.Info("opening websocket connection to Discord")
-
-	discordImpl.session.Open()
-
+	d.logger.Info("opening websocket connection to Discord")
+	d.session.AddHandler(d.handleConnect)
+	d.session.AddHandler(d.handleDisconnect)
+	d.session.AddHandler(d.handleInteractions)
+	d.session.AddHandler(d.handleMemberUpdate)
+	err := d.session.Open()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open websocket connection to Discord: %w", err)
+		return fmt.Errorf("failed to open websocket connection to Discord: %w", err)
+	}
+	d.logger.Infof("successfully opened websocket connection to Discord")
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	discordImpl.logger.Infof("successfully opened websocket connection to Discord")
-
-	discordImpl.logger.Infof("attaching slash command handler")
-
-	// c.Logger.Infof("reloading roles from config")
-	// err := c.reloadRoles()
-	// if err != nil {
-	// 	return fmt.Errorf("failed to reload config roles")
-	// }
-
-	err = discordImpl.registerSlashCommands()
+	err = d.registerSlashCommands()
 	if err != nil {
-		return nil, fmt.Errorf("failed to register slash commands: %w", err)
+		return fmt.Errorf("failed to register slash commands: %w", err)
 	}
+	atomic.StoreInt32(&d.commandsRegistered, 1)
 
-	err = discordImpl.SendChannelMessage(discordImpl.primaryChannelID, "Hello! Thank you for inviting me!")
-	if err != nil {
-		return nil, fmt.Errorf("failed to send welcome message: %w", err)
+	if d.greeting == "" {
+		return nil
 	}
-	discordImpl.logger.Infof("successfully sent welcome message")
+	for _, guildID := range d.guildOrder {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = d.SendChannelMessage(guildID, "", d.greeting)
+		if err != nil {
+			return fmt.Errorf("failed to send greeting to guild %s: %w", guildID, err)
+		}
+	}
+	d.logger.Infof("successfully sent greeting")
+	return nil
+}
 
-	return discordImpl, nil
+// Stop turns down every following slash command and says farewell to every guild. It does not close the session
+// so that the bot can still send messages until Close is called.
+func (d *DiscordImpl) Stop(ctx context.Context) error {
+	d.StopAcceptingCommands()
+	if d.farewell == "" || atomic.LoadInt32(&d.started) == 0 {
+		return nil
+	}
+	var errs *multierror.Error
+	for _, guildID := range d.guildOrder {
+		if ctx.Err() != nil {
+			errs = multierror.Append(errs, ctx.Err())
+			break
+		}
+		err := d.SendChannelMessage(guildID, "", d.farewell)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed to send farewell to guild %s: %w", guildID, err))
+		}
+	}
+	return errs.ErrorOrNil()
 }
 
 func (d *DiscordImpl) handleMemberUpdate(s *discordgo.Session, i *discordgo.GuildMemberUpdate) {
+	if _, err := d.guild(i.GuildID); err != nil {
+		return
+	}
 	cachedUser, err := s.State.Member(i.GuildID, i.User.ID)
 	if err != nil {
 		return
@@ -316,7 +645,7 @@ func (d *DiscordImpl) handleMemberUpdate(s *discordgo.Session, i *discordgo.Guil
 
 	//call the callback for each removed role.
 	for _, role := range removed {
-		d.roleRemovedCallback(cachedUser.User.ID, role)
+		d.roleRemovedCallback(i.GuildID, cachedUser.User.ID, role)
 	}
 
 }
@@ -325,45 +654,88 @@ func (d *DiscordImpl) handleInteractions(s *discordgo.Session, i *discordgo.Inte
 	// Step 1: Figure out which one of the three interactions just happened.
 	switch i.Interaction.Type {
 	case discordgo.InteractionApplicationCommand:
+		if atomic.LoadInt32(&d.closing) == 1 {
+			metrics.SlashCommands.WithLabelValues(i.ApplicationCommandData().Name, metrics.CommandShuttingDown).Inc()
+			err := respondEphemeral(s, i, "Panicbot is shutting down and is not accepting new commands. Please try again once it is back.")
+			if err != nil {
+				d.logger.Errorf("failed to respond to application command: %s", err.Error())
+			}
+			return
+		}
+		// Slash commands are only registered in guilds, but a guild may have been removed from the config since.
+		g, err := d.guild(i.GuildID)
+		if err != nil || i.Member == nil {
+			metrics.SlashCommands.WithLabelValues(i.ApplicationCommandData().Name, metrics.CommandNotConfigured).Inc()
+			err := respondEphemeral(s, i, "Panicbot is not configured for this server.")
+			if err != nil {
+				d.logger.Errorf("failed to respond to application command: %s", err.Error())
+			}
+			return
+		}
+		rules := g.commandRules()
 		if i.ApplicationCommandData().Name == "panicalert" {
-			if !hasCommandPermissions(d.allowedToVote.PanicAlert.Users, i.Member.User.ID, d.allowedToVote.PanicAlert.Roles, i.Member.Roles) {
-				handlePermissionsBadRequest(s, i)
-			} else {
-				err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						// Here is where we would create the JSON Payload for an embedded message.
-						// A listener for the InteractionMessageComponent has already been added.
-						// So theoretically, whenever a button is clicked on we can respond to it with the embedButtonCallback.
-						Content: "Beginning panic alert vote",
-					},
-				})
+			if !rules.AllowedToVote.PanicAlert.Allows(interactionMember(i)) {
+				d.handlePermissionsBadRequest(s, i, g, "panicalert", rules.AbuseTracking)
+			} else if !d.onCooldown(s, i, g, "panicalert", rules.Cooldown.PanicAlert) &&
+				!d.rateLimited(s, i, g, "panicalert", rules.RateLimit.PanicAlert.Hour, rules.RateLimit.PanicAlert.Day) {
+				// Notifying the voters can take longer than the three seconds Discord waits for a response.
+				err := deferEphemeral(s, i)
+				metrics.SlashCommands.WithLabelValues("panicalert", commandOutcome(err)).Inc()
 				if err != nil {
-					d.logger.Errorf("failed to respond to application command: %w", err)
+					d.logger.Errorf("failed to respond to application command: %s", err.Error())
 					return
 				}
-				d.panicAlertCallback(i.ApplicationCommandData().Options[0].Value.(string))
+				message := ""
+				for _, option := range i.ApplicationCommandData().Options {
+					if option.Name == "message" {
+						message = option.StringValue()
+					}
+				}
+				notice, err := d.panicAlertCallback(g.id, i.Member.User.ID, message)
+				if err == nil {
+					g.rateLimiter.record("panicalert", time.Now())
+				}
+				d.reportVoteStarted(s, i, "panic alert", notice, err)
 			}
 		}
+		if i.ApplicationCommandData().Name == "panicconfig" && d.panicConfigCallback != nil {
+			d.handlePanicConfig(s, i, g)
+		}
+		if i.ApplicationCommandData().Name == "panicaudit" && d.panicAuditCallback != nil {
+			d.handlePanicAudit(s, i, g)
+		}
 		if i.ApplicationCommandData().Name == "panicban" {
 			slashCommandData := i.ApplicationCommandData()
-			if !hasCommandPermissions(d.allowedToVote.PanicBan.Users, i.Member.User.ID, d.allowedToVote.PanicBan.Roles, i.Member.Roles) {
-				handlePermissionsBadRequest(s, i)
-			} else {
-				err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-					Type: discordgo.InteractionResponseChannelMessageWithSource,
-					Data: &discordgo.InteractionResponseData{
-						Content: "🚨A Panic Ban vote has started! Voters check your DMs. This message will self-destruct in one second.🚨",
-					},
-				})
+			if !rules.AllowedToVote.PanicBan.Allows(interactionMember(i)) {
+				d.handlePermissionsBadRequest(s, i, g, "panicban", rules.AbuseTracking)
+			} else if !d.onCooldown(s, i, g, "panicban", rules.Cooldown.PanicBan) &&
+				!d.rateLimited(s, i, g, "panicban", rules.RateLimit.PanicBan.Hour, rules.RateLimit.PanicBan.Day) {
+				// The response is only shown to the member that used the command so that the target does not learn
+				// about the vote.
+				err := deferEphemeral(s, i)
+				metrics.SlashCommands.WithLabelValues("panicban", commandOutcome(err)).Inc()
 				if err != nil {
-					d.logger.Errorf("failed to respond to application command: %w", err)
+					d.logger.Errorf("failed to respond to application command: %s", err.Error())
 					return
 				}
-				time.AfterFunc(time.Second*1, func() {
-					s.InteractionResponseDelete(i.Interaction)
-				})
-				d.panicBanCallback(i.Interaction.Member.User.ID, slashCommandData.Options[0].Value.(string), slashCommandData.Options[1].Value.(string), slashCommandData.Options[2].Value.(float64))
+				var targetUserID, reason string
+				// days is optional, no messages are deleted when it is left out.
+				var days float64
+				for _, option := range slashCommandData.Options {
+					switch option.Name {
+					case "user":
+						targetUserID = option.Value.(string)
+					case "reason":
+						reason = option.StringValue()
+					case "days":
+						days = float64(option.IntValue())
+					}
+				}
+				notice, err := d.panicBanCallback(g.id, i.Member.User.ID, targetUserID, reason, days)
+				if err == nil {
+					g.rateLimiter.record("panicban", time.Now())
+				}
+				d.reportVoteStarted(s, i, "panic ban", notice, err)
 			}
 		}
 	// This makes the assumption that an InteractionMessageComponent event is fired whenever an embedded button is clicked on.
@@ -383,7 +755,6 @@ func (d *DiscordImpl) handleInteractions(s *discordgo.Session, i *discordgo.Inte
 	// Step 2: Pull the data from the interaction that we care about(going to depend on which interaction)
 	// Step 3: Pass that information to the matching callback.
 	// Step 4: ? Handle the response to the command so that discord doesn't error. We should not pass the session or the interaction create to the callbacks.
-	pp.Println(i)
 }
 
 func (d *DiscordImpl) registerSlashCommands() error {
@@ -430,44 +801,58 @@ func (d *DiscordImpl) registerSlashCommands() error {
 			},
 		},
 	}
+	if d.panicConfigCallback != nil {
+		commands = append(commands, panicConfigCommand(d.panicConfigSettings))
+	}
+	if d.panicAuditCallback != nil {
+		commands = append(commands, panicAuditCommand(d.panicAuditEvents))
+	}
 
-	// Add a listener for when the Discord API fires an InteractionCreate event.
-	d.session.AddHandler(d.handleInteractions)
-	d.session.AddHandler(d.handleMemberUpdate)
-	for _, v := range commands {
-		_, err := d.session.ApplicationCommandCreate(d.session.State.User.ID, d.guildID, v)
+	for _, guildID := range d.guildOrder {
+		err := d.syncCommands(guildID, commands)
 		if err != nil {
-			return fmt.Errorf("cannot create '%v' command: %v", v.Name, err)
+			return err
 		}
 	}
 	return nil
 }
 
-func (d *DiscordImpl) findPrimaryChannelInGuild() (string, error) {
+func (d *DiscordImpl) findPrimaryChannelInGuild(g *guild) (string, error) {
 	// The primary channel may be provided to us in the config.yml
-	if d.primaryChannelID != "" {
-		channel, err := d.session.Channel(d.primaryChannelID)
+	if g.primaryChannelID != "" {
+		channel, err := d.session.Channel(g.primaryChannelID)
 		if err != nil {
 			return "", fmt.Errorf("failed to find channel with provided identifier. Is primaryChannelID a valid Discord channelID?: %w", err)
 		}
 		return channel.ID, nil
 	}
 
-	guild, err := d.session.Guild(d.guildID)
+	guild, err := d.session.Guild(g.id)
 	if err != nil {
 		return "", fmt.Errorf("failed to find guild with provided identifier. Did you forget to put the GuildID in the config?: %w", err)
 	}
 
-	channels, err := d.session.GuildChannels(d.guildID)
+	channels, err := d.session.GuildChannels(g.id)
 	if err != nil {
 		return "", fmt.Errorf("failed to find channels in the guild: %w", err)
 	}
 
+	// Guilds created before 2017 have a default channel that shares the ID of the guild. Newer guilds do not, the
+	// text channel at the top of the channel list is used instead.
+	var primary *discordgo.Channel
 	for _, guildChannel := range channels {
 		if guildChannel.ID == guild.ID {
 			return guildChannel.ID, nil
 		}
+		if guildChannel.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+		if primary == nil || guildChannel.Position < primary.Position {
+			primary = guildChannel
+		}
 	}
-
-	return "", nil
+	if primary == nil {
+		return "", fmt.Errorf("guild %s has no text channel. Set PrimaryChannelID in the config", g.id)
+	}
+	return primary.ID, nil
 }
@@ -0,0 +1,455 @@
+package panicbot_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot"
+	"github.com/streemtech/panicbot/discordtest"
+)
+
+const (
+	testGuildID   = "111111111111111111"
+	testChannelID = "111111111111111112"
+	testModRole   = "111111111111111113"
+)
+
+// newTestServer returns a discordtest.Server serving one guild with a mod role and a primary text channel.
+func newTestServer(t *testing.T) *discordtest.Server {
+	t.Helper()
+	server := discordtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddGuild(testGuildID, "test guild", discordgo.PermissionViewChannel)
+	server.AddRole(testGuildID, &discordgo.Role{ID: testModRole, Name: "mod", Permissions: discordgo.PermissionBanMembers})
+	server.AddChannel(&discordgo.Channel{ID: testChannelID, GuildID: testGuildID, Name: "general", Type: discordgo.ChannelTypeGuildText})
+	return server
+}
+
+// newTestDiscordArgs returns the arguments of a DiscordImpl that sends every request to server. The callbacks do
+// nothing.
+func newTestDiscordArgs(server *discordtest.Server) *panicbot.DiscordImplArgs {
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	return &panicbot.DiscordImplArgs{
+		Guilds:                []panicbot.GuildArgs{{GuildID: testGuildID, PrimaryChannelID: testChannelID}},
+		BotToken:              "test",
+		APIBaseURL:            server.URL,
+		Logger:                logger,
+		EmbedReactionCallback: func(userID, buttonID string) {},
+		PanicAlertCallback: func(guildID, userID, message string) (panicbot.VoteNotice, error) {
+			return panicbot.VoteNotice{}, nil
+		},
+		PanicBanCallback: func(guildID, userID, targetUserID, reason string, days float64) (panicbot.VoteNotice, error) {
+			return panicbot.VoteNotice{}, nil
+		},
+	}
+}
+
+// newTestDiscord returns a DiscordImpl that sends every request to a discordtest.Server serving one guild.
+func newTestDiscord(t *testing.T) (*panicbot.DiscordImpl, *discordtest.Server) {
+	t.Helper()
+	server := newTestServer(t)
+	d, err := panicbot.NewDiscord(newTestDiscordArgs(server))
+	if err != nil {
+		t.Fatalf("failed to create Discord: %s", err.Error())
+	}
+	return d, server
+}
+
+// startDiscord creates a DiscordImpl from args and starts it. It is closed when the test ends.
+func startDiscord(t *testing.T, args *panicbot.DiscordImplArgs) *panicbot.DiscordImpl {
+	t.Helper()
+	d, err := panicbot.NewDiscord(args)
+	if err != nil {
+		t.Fatalf("failed to create Discord: %s", err.Error())
+	}
+	err = d.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start failed: %s", err.Error())
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// memberID returns the user ID of the n-th seeded member. IDs sort in the order the members were added.
+func memberID(n int) string {
+	return fmt.Sprintf("3%017d", n)
+}
+
+// seedMembers adds count members to the guild. Every third member holds the mod role.
+func seedMembers(server *discordtest.Server, count int) {
+	for n := 1; n <= count; n++ {
+		member := &discordgo.Member{User: &discordgo.User{ID: memberID(n), Username: fmt.Sprintf("member%d", n)}}
+		if n%3 == 0 {
+			member.Roles = []string{testModRole}
+		}
+		server.AddMember(testGuildID, member)
+	}
+}
+
+func TestGetAllGuildMembersPagination(t *testing.T) {
+	tests := []struct {
+		members  int
+		requests []string
+	}{
+		{
+			members: 999,
+			requests: []string{
+				"GET /guilds/" + testGuildID + "/members?limit=1000",
+			},
+		},
+		{
+			// A full page does not tell whether there are more members, so one more page is requested.
+			members: 1000,
+			requests: []string{
+				"GET /guilds/" + testGuildID + "/members?limit=1000",
+				"GET /guilds/" + testGuildID + "/members?after=" + memberID(1000) + "&limit=1000",
+			},
+		},
+		{
+			members: 2500,
+			requests: []string{
+				"GET /guilds/" + testGuildID + "/members?limit=1000",
+				"GET /guilds/" + testGuildID + "/members?after=" + memberID(1000) + "&limit=1000",
+				"GET /guilds/" + testGuildID + "/members?after=" + memberID(2000) + "&limit=1000",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d members", test.members), func(t *testing.T) {
+			d, server := newTestDiscord(t)
+			seedMembers(server, test.members)
+
+			members, err := d.GetAllGuildMembers(testGuildID)
+			if err != nil {
+				t.Fatalf("GetAllGuildMembers failed: %s", err.Error())
+			}
+
+			want := append(test.requests, "GET /guilds/"+testGuildID+"/roles")
+			if got := server.Requests(); !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected requests:\n%v\nwant:\n%v", got, want)
+			}
+			if len(members) != test.members {
+				t.Fatalf("expected %d members, got %d", test.members, len(members))
+			}
+			seen := make(map[string]bool, len(members))
+			for _, member := range members {
+				if seen[member.UserID] {
+					t.Fatalf("member %s was returned twice", member.UserID)
+				}
+				seen[member.UserID] = true
+			}
+			// Permissions combine @everyone with the roles of the member.
+			mod := members[2]
+			if mod.UserID != memberID(3) || !reflect.DeepEqual(mod.Roles, []string{testModRole}) {
+				t.Fatalf("expected the third member to hold the mod role, got %+v", mod)
+			}
+			if mod.Permissions != discordgo.PermissionViewChannel|discordgo.PermissionBanMembers {
+				t.Errorf("unexpected permissions of a mod: %b", mod.Permissions)
+			}
+			if members[0].Permissions != discordgo.PermissionViewChannel {
+				t.Errorf("unexpected permissions of a member without roles: %b", members[0].Permissions)
+			}
+		})
+	}
+}
+
+func TestSendDMEmbed(t *testing.T) {
+	d, server := newTestDiscord(t)
+	userID := memberID(1)
+
+	err := d.SendDMEmbed(userID, "User <@2> has triggered a Panic Ban vote", "**Reason:** spam", "🚨 Panic Ban Vote 🚨", "Ban User", "vote-1")
+	if err != nil {
+		t.Fatalf("SendDMEmbed failed: %s", err.Error())
+	}
+
+	want := []string{
+		"POST /users/@me/channels",
+		"POST /channels/dm-" + userID + "/messages",
+	}
+	if got := server.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected requests:\n%v\nwant:\n%v", got, want)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	if messages[0].ChannelID != "dm-"+userID {
+		t.Errorf("expected the message in the DM channel of the user, got %s", messages[0].ChannelID)
+	}
+	payload, err := json.Marshal(struct {
+		Content    string                    `json:"content"`
+		Embeds     []*discordgo.MessageEmbed `json:"embeds"`
+		Components json.RawMessage           `json:"components"`
+	}{messages[0].Content, messages[0].Embeds, messages[0].Components})
+	if err != nil {
+		t.Fatalf("failed to encode message: %s", err.Error())
+	}
+	assertJSONFixture(t, payload, "dm_embed.json")
+}
+
+// assertJSONFixture compares got with the JSON in testdata/fixture, ignoring formatting.
+func assertJSONFixture(t *testing.T, got []byte, fixture string) {
+	t.Helper()
+	want, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %s", err.Error())
+	}
+	var gotValue, wantValue any
+	err = json.Unmarshal(got, &gotValue)
+	if err != nil {
+		t.Fatalf("failed to decode %s: %s", got, err.Error())
+	}
+	err = json.Unmarshal(want, &wantValue)
+	if err != nil {
+		t.Fatalf("failed to decode fixture %s: %s", fixture, err.Error())
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("payload does not match %s:\n%s\nwant:\n%s", fixture, got, want)
+	}
+}
+
+func TestBanUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		days    int
+		request string
+	}{
+		{
+			name:    "deleting messages",
+			days:    7,
+			request: "PUT /guilds/" + testGuildID + "/bans/" + memberID(1) + "?delete_message_days=7&reason=spamming+slurs",
+		},
+		{
+			name:    "keeping messages",
+			days:    0,
+			request: "PUT /guilds/" + testGuildID + "/bans/" + memberID(1) + "?reason=spamming+slurs",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, server := newTestDiscord(t)
+			seedMembers(server, 2)
+
+			err := d.BanUser(testGuildID, memberID(1), "spamming slurs", test.days)
+			if err != nil {
+				t.Fatalf("BanUser failed: %s", err.Error())
+			}
+
+			want := []string{
+				test.request,
+				"GET /guilds/" + testGuildID + "/bans/" + memberID(1),
+			}
+			if got := server.Requests(); !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected requests:\n%v\nwant:\n%v", got, want)
+			}
+			bans := server.Bans(testGuildID)
+			if len(bans) != 1 || bans[0].User.ID != memberID(1) || bans[0].Reason != "spamming slurs" {
+				t.Fatalf("unexpected bans: %+v", bans)
+			}
+			members, err := d.GetAllGuildMembers(testGuildID)
+			if err != nil {
+				t.Fatalf("GetAllGuildMembers failed: %s", err.Error())
+			}
+			if len(members) != 1 || members[0].UserID != memberID(2) {
+				t.Errorf("expected the banned member to be removed from the guild, got %+v", members)
+			}
+		})
+	}
+}
+
+func TestBanUserFails(t *testing.T) {
+	d, _ := newTestDiscord(t)
+
+	err := d.BanUser("999999999999999999", memberID(1), "spam", 0)
+	if err == nil {
+		t.Fatalf("expected a ban in an unknown guild to fail")
+	}
+}
+
+func TestStartWithConfiguredPrimaryChannel(t *testing.T) {
+	server := newTestServer(t)
+	args := newTestDiscordArgs(server)
+	args.Greeting = "hello"
+	d := startDiscord(t, args)
+
+	err := d.Ready()
+	if err != nil {
+		t.Errorf("expected the bot to be ready once started: %s", err.Error())
+	}
+	messages := server.Messages()
+	if len(messages) != 1 || messages[0].ChannelID != testChannelID || messages[0].Content != "hello" {
+		t.Errorf("expected the greeting in the configured channel, got %+v", messages)
+	}
+	requests := server.Requests()
+	if len(requests) == 0 || requests[0] != "GET /channels/"+testChannelID {
+		t.Errorf("expected the configured channel to be looked up first, got %v", requests)
+	}
+	names := make([]string, 0)
+	for _, command := range server.Commands(testGuildID) {
+		names = append(names, command.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"panicalert", "panicban"}) {
+		t.Errorf("expected the panic commands to be registered, got %v", names)
+	}
+}
+
+func TestStartDerivesPrimaryChannel(t *testing.T) {
+	server := discordtest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddGuild(testGuildID, "test guild", discordgo.PermissionViewChannel)
+	server.AddChannel(&discordgo.Channel{ID: "211111111111111111", GuildID: testGuildID, Name: "Voice", Type: discordgo.ChannelTypeGuildVoice, Position: 0})
+	server.AddChannel(&discordgo.Channel{ID: "211111111111111112", GuildID: testGuildID, Name: "Text Channels", Type: discordgo.ChannelTypeGuildCategory, Position: 1})
+	server.AddChannel(&discordgo.Channel{ID: "211111111111111113", GuildID: testGuildID, Name: "rules", Type: discordgo.ChannelTypeGuildText, Position: 3})
+	server.AddChannel(&discordgo.Channel{ID: "211111111111111114", GuildID: testGuildID, Name: "welcome", Type: discordgo.ChannelTypeGuildText, Position: 2})
+	args := newTestDiscordArgs(server)
+	args.Guilds[0].PrimaryChannelID = ""
+	args.Greeting = "hello"
+	d := startDiscord(t, args)
+
+	messages := server.Messages()
+	if len(messages) != 1 || messages[0].ChannelID != "211111111111111114" {
+		t.Fatalf("expected the greeting in the topmost text channel, got %+v", messages)
+	}
+	err := d.SendChannelMessage(testGuildID, "", "vote failed")
+	if err != nil {
+		t.Fatalf("SendChannelMessage failed: %s", err.Error())
+	}
+	if messages := server.Messages(); len(messages) != 2 || messages[1].ChannelID != "211111111111111114" {
+		t.Errorf("expected later messages in the derived channel, got %+v", messages)
+	}
+}
+
+func TestStartWithoutPrimaryChannel(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+	}{
+		{name: "no text channel", channelID: ""},
+		{name: "unknown configured channel", channelID: "299999999999999999"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := discordtest.NewServer()
+			t.Cleanup(server.Close)
+			server.AddGuild(testGuildID, "test guild", discordgo.PermissionViewChannel)
+			server.AddChannel(&discordgo.Channel{ID: "211111111111111111", GuildID: testGuildID, Name: "Voice", Type: discordgo.ChannelTypeGuildVoice})
+			args := newTestDiscordArgs(server)
+			args.Guilds[0].PrimaryChannelID = test.channelID
+			d, err := panicbot.NewDiscord(args)
+			if err != nil {
+				t.Fatalf("failed to create Discord: %s", err.Error())
+			}
+
+			err = d.Start(context.Background())
+			if err == nil {
+				d.Close()
+				t.Fatalf("expected Start to fail without a primary channel")
+			}
+			if !strings.Contains(err.Error(), "primary channel") {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+// waitFor polls condition until it is true or a second has passed.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestPanicBanRepliesPrivately(t *testing.T) {
+	server := newTestServer(t)
+	args := newTestDiscordArgs(server)
+	args.Guilds[0].Rules.AllowedToVote.PanicBan = panicbot.PermissionRule{Roles: []string{testModRole}}
+	type ban struct {
+		guildID, userID, targetUserID, reason string
+		days                                  float64
+	}
+	bans := make(chan ban, 1)
+	args.PanicBanCallback = func(guildID, userID, targetUserID, reason string, days float64) (panicbot.VoteNotice, error) {
+		bans <- ban{guildID, userID, targetUserID, reason, days}
+		return panicbot.VoteNotice{Notified: 3, Failed: 1}, nil
+	}
+	startDiscord(t, args)
+
+	mod := &discordgo.Member{User: &discordgo.User{ID: memberID(3), Username: "mod"}, Roles: []string{testModRole}}
+	token, err := server.SlashCommand(testGuildID, mod, "panicban",
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "user", Type: discordgo.ApplicationCommandOptionUser, Value: memberID(1)},
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "reason", Type: discordgo.ApplicationCommandOptionString, Value: "spam"},
+		&discordgo.ApplicationCommandInteractionDataOption{Name: "days", Type: discordgo.ApplicationCommandOptionInteger, Value: 2},
+	)
+	if err != nil {
+		t.Fatalf("SlashCommand failed: %s", err.Error())
+	}
+	waitFor(t, "the response to be edited", func() bool {
+		replies := server.Replies()
+		return len(replies) == 1 && replies[0].Content != ""
+	})
+
+	want := ban{testGuildID, memberID(3), memberID(1), "spam", 2}
+	if got := <-bans; got != want {
+		t.Errorf("expected the vote %+v to be started, got %+v", want, got)
+	}
+	reply := server.Replies()[0]
+	if reply.Token != token || reply.Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Errorf("expected a deferred response, got type %d", reply.Type)
+	}
+	if reply.Flags != uint64(discordgo.MessageFlagsEphemeral) {
+		t.Errorf("expected the response to only be shown to the member, got flags %d", reply.Flags)
+	}
+	if reply.Content != "Your panic ban vote has started. 3 voter(s) were notified, 1 direct message(s) could not be delivered." {
+		t.Errorf("unexpected response: %s", reply.Content)
+	}
+	if messages := server.Messages(); len(messages) != 0 {
+		t.Errorf("expected nothing to be posted about the ban vote, got %+v", messages)
+	}
+}
+
+func TestPanicConfigWithoutSubcommand(t *testing.T) {
+	server := newTestServer(t)
+	args := newTestDiscordArgs(server)
+	called := make(chan panicbot.PanicConfigRequest, 1)
+	args.PanicConfigCallback = func(guildID, userID string, request panicbot.PanicConfigRequest) string {
+		called <- request
+		return ""
+	}
+	startDiscord(t, args)
+
+	admin := &discordgo.Member{User: &discordgo.User{ID: memberID(1), Username: "admin"}, Permissions: discordgo.PermissionAdministrator}
+	_, err := server.SlashCommand(testGuildID, admin, "panicconfig")
+	if err != nil {
+		t.Fatalf("SlashCommand failed: %s", err.Error())
+	}
+	waitFor(t, "a response", func() bool { return len(server.Replies()) == 1 })
+
+	reply := server.Replies()[0]
+	if reply.Type != discordgo.InteractionResponseChannelMessageWithSource || reply.Flags != uint64(discordgo.MessageFlagsEphemeral) {
+		t.Errorf("expected a private response, got type %d with flags %d", reply.Type, reply.Flags)
+	}
+	if !strings.HasPrefix(reply.Content, "Usage: /panicconfig view") {
+		t.Errorf("expected the usage to be shown, got %q", reply.Content)
+	}
+	select {
+	case request := <-called:
+		t.Errorf("expected the callback not to be called, got %+v", request)
+	default:
+	}
+}
@@ -0,0 +1,487 @@
+// Package discordtest runs the bot without a connection to Discord. Fake is a panicbot.Discord that records everything
+// the bot sends and lets slash commands and button clicks be injected into the callbacks of the bot, so that whole
+// votes can be run in process. Server is a local stand-in for the Discord API that DiscordImpl itself can be run
+// against.
+package discordtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streemtech/panicbot"
+)
+
+// DM is a direct message sent with SendDM.
+type DM struct {
+	UserID  string
+	Message string
+}
+
+// Embed is a direct message with an embed and a button sent with SendDMEmbed.
+type Embed struct {
+	UserID      string
+	Content     string
+	Description string
+	Title       string
+	ButtonLabel string
+	ButtonID    string
+}
+
+// ChannelMessage is a message sent with SendChannelMessage. ChannelID is the primary channel of the guild if no
+// channel was given.
+type ChannelMessage struct {
+	GuildID   string
+	ChannelID string
+	Message   string
+}
+
+// Ban is a ban executed with BanUser.
+type Ban struct {
+	GuildID string
+	UserID  string
+	Reason  string
+	Days    int
+}
+
+// Member is a member of a fake guild.
+type Member struct {
+	panicbot.UserRoles
+	Username string
+}
+
+// fakeGuild is the state of one guild of the Fake.
+type fakeGuild struct {
+	primaryChannelID string
+	rules            panicbot.CommandRules
+	members          map[string]Member
+}
+
+// Fake implements panicbot.Discord without connecting to Discord. It is safe for concurrent use.
+type Fake struct {
+	mu         sync.Mutex
+	guilds     map[string]*fakeGuild
+	guildOrder []string
+	started    bool
+	closing    bool
+	closed     bool
+	errors     map[string]error
+	greeting   string
+	farewell   string
+
+	dms             []DM
+	embeds          []Embed
+	channelMessages []ChannelMessage
+	bans            []Ban
+
+	embedReactionCallback    func(userID, buttonID string)
+	panicAlertCallback       func(guildID, userID, message string) (panicbot.VoteNotice, error)
+	panicBanCallback         func(guildID, userID, targetUserID, reason string, days float64) (panicbot.VoteNotice, error)
+	roleRemovedCallback      func(guildID, user, role string)
+	panicConfigCallback      func(guildID, userID string, request panicbot.PanicConfigRequest) string
+	panicAuditCallback       func(guildID, userID string, request panicbot.PanicAuditRequest) string
+	permissionDeniedCallback func(guildID, userID, command string)
+}
+
+var _ panicbot.Discord = (*Fake)(nil)
+
+// New returns a Fake serving the guilds of args and calling its callbacks. BotToken, Logger and Session are ignored.
+// A guild without a PrimaryChannelID gets one named after the guild.
+func New(args *panicbot.DiscordImplArgs) (*Fake, error) {
+	if len(args.Guilds) == 0 {
+		return nil, fmt.Errorf("Guilds cannot be empty")
+	}
+	f := &Fake{
+		guilds:                   make(map[string]*fakeGuild, len(args.Guilds)),
+		errors:                   make(map[string]error),
+		greeting:                 args.Greeting,
+		farewell:                 args.Farewell,
+		embedReactionCallback:    args.EmbedReactionCallback,
+		panicAlertCallback:       args.PanicAlertCallback,
+		panicBanCallback:         args.PanicBanCallback,
+		roleRemovedCallback:      args.RoleRemovedCallback,
+		panicConfigCallback:      args.PanicConfigCallback,
+		panicAuditCallback:       args.PanicAuditCallback,
+		permissionDeniedCallback: args.PermissionDeniedCallback,
+	}
+	for _, guildArgs := range args.Guilds {
+		if guildArgs.GuildID == "" {
+			return nil, fmt.Errorf("GuildID cannot be empty")
+		}
+		if _, ok := f.guilds[guildArgs.GuildID]; ok {
+			return nil, fmt.Errorf("guild %s is configured more than once", guildArgs.GuildID)
+		}
+		primaryChannelID := guildArgs.PrimaryChannelID
+		if primaryChannelID == "" {
+			primaryChannelID = guildArgs.GuildID + "-primary"
+		}
+		f.guilds[guildArgs.GuildID] = &fakeGuild{
+			primaryChannelID: primaryChannelID,
+			rules:            guildArgs.Rules,
+			members:          make(map[string]Member),
+		}
+		f.guildOrder = append(f.guildOrder, guildArgs.GuildID)
+	}
+	if args.EmbedReactionCallback == nil || args.PanicAlertCallback == nil || args.PanicBanCallback == nil {
+		return nil, fmt.Errorf("EmbedReactionCallback, PanicAlertCallback and PanicBanCallback must be set")
+	}
+	return f, nil
+}
+
+// guild returns the guild with the given ID. f.mu must be held.
+func (f *Fake) guild(guildID string) (*fakeGuild, error) {
+	g, ok := f.guilds[guildID]
+	if !ok {
+		return nil, fmt.Errorf("guild %s is not configured", guildID)
+	}
+	return g, nil
+}
+
+// SetError makes every following call of method, e.g. "SendDM", fail with err. A nil err makes it succeed again.
+func (f *Fake) SetError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errors, method)
+		return
+	}
+	f.errors[method] = err
+}
+
+// AddMember adds a member to the guild or replaces it. Members are returned by GetAllGuildMembers and their roles
+// and permissions decide whether injected commands are allowed.
+func (f *Fake) AddMember(guildID string, member Member) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.guild(guildID)
+	if err != nil {
+		return err
+	}
+	g.members[member.UserID] = member
+	return nil
+}
+
+// RemoveRole removes role from the member and calls the RoleRemovedCallback like a guild member update would.
+func (f *Fake) RemoveRole(guildID, userID, role string) error {
+	f.mu.Lock()
+	g, err := f.guild(guildID)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	member, ok := g.members[userID]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("user %s is not a member of guild %s", userID, guildID)
+	}
+	roles := make([]string, 0, len(member.Roles))
+	for _, r := range member.Roles {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	member.Roles = roles
+	g.members[userID] = member
+	f.mu.Unlock()
+
+	if f.roleRemovedCallback != nil {
+		f.roleRemovedCallback(guildID, userID, role)
+	}
+	return nil
+}
+
+// command checks that userID may use command in the guild like DiscordImpl does before calling its callback. It
+// does not apply cooldowns or rate limits.
+func (f *Fake) command(guildID, userID, command string, allowed func(rules panicbot.CommandRules, member panicbot.UserRoles) bool) error {
+	f.mu.Lock()
+	if f.closing {
+		f.mu.Unlock()
+		return fmt.Errorf("not accepting commands, the bot is shutting down")
+	}
+	g, err := f.guild(guildID)
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	member, ok := g.members[userID]
+	if !ok {
+		member = Member{UserRoles: panicbot.UserRoles{UserID: userID}}
+	}
+	rules := g.rules
+	f.mu.Unlock()
+
+	if allowed(rules, member.UserRoles) {
+		return nil
+	}
+	if f.permissionDeniedCallback != nil {
+		f.permissionDeniedCallback(guildID, userID, command)
+	}
+	return fmt.Errorf("user %s is not allowed to use /%s", userID, command)
+}
+
+// PanicAlert injects /panicalert used by userID and returns what the member would be told about the vote.
+func (f *Fake) PanicAlert(guildID, userID, message string) (panicbot.VoteNotice, error) {
+	err := f.command(guildID, userID, "panicalert", func(rules panicbot.CommandRules, member panicbot.UserRoles) bool {
+		return rules.AllowedToVote.PanicAlert.Allows(member)
+	})
+	if err != nil {
+		return panicbot.VoteNotice{}, err
+	}
+	return f.panicAlertCallback(guildID, userID, message)
+}
+
+// PanicBan injects /panicban used by userID against targetUserID and returns what the member would be told about
+// the vote.
+func (f *Fake) PanicBan(guildID, userID, targetUserID, reason string, days float64) (panicbot.VoteNotice, error) {
+	err := f.command(guildID, userID, "panicban", func(rules panicbot.CommandRules, member panicbot.UserRoles) bool {
+		return rules.AllowedToVote.PanicBan.Allows(member)
+	})
+	if err != nil {
+		return panicbot.VoteNotice{}, err
+	}
+	return f.panicBanCallback(guildID, userID, targetUserID, reason, days)
+}
+
+// PanicConfig injects /panicconfig used by an administrator and returns the response shown to them.
+func (f *Fake) PanicConfig(guildID, userID string, request panicbot.PanicConfigRequest) (string, error) {
+	if f.panicConfigCallback == nil {
+		return "", fmt.Errorf("/panicconfig is not registered")
+	}
+	err := f.command(guildID, userID, "panicconfig", isAdministrator)
+	if err != nil {
+		return "", err
+	}
+	return f.panicConfigCallback(guildID, userID, request), nil
+}
+
+// PanicAudit injects /panicaudit used by an administrator and returns the response shown to them.
+func (f *Fake) PanicAudit(guildID, userID string, request panicbot.PanicAuditRequest) (string, error) {
+	if f.panicAuditCallback == nil {
+		return "", fmt.Errorf("/panicaudit is not registered")
+	}
+	err := f.command(guildID, userID, "panicaudit", isAdministrator)
+	if err != nil {
+		return "", err
+	}
+	return f.panicAuditCallback(guildID, userID, request), nil
+}
+
+func isAdministrator(rules panicbot.CommandRules, member panicbot.UserRoles) bool {
+	return member.Permissions&discordgo.PermissionAdministrator != 0
+}
+
+// ClickButton injects a click of userID on the button with buttonID, e.g. the vote button of an embed.
+func (f *Fake) ClickButton(userID, buttonID string) {
+	f.embedReactionCallback(userID, buttonID)
+}
+
+// DMs returns the direct messages sent so far.
+func (f *Fake) DMs() []DM {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]DM(nil), f.dms...)
+}
+
+// Embeds returns the direct messages with an embed sent so far.
+func (f *Fake) Embeds() []Embed {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Embed(nil), f.embeds...)
+}
+
+// ChannelMessages returns the channel messages sent so far.
+func (f *Fake) ChannelMessages() []ChannelMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ChannelMessage(nil), f.channelMessages...)
+}
+
+// Bans returns the bans executed so far.
+func (f *Fake) Bans() []Ban {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Ban(nil), f.bans...)
+}
+
+// CommandRules returns the rules of the guild as last set with UpdateCommandRules.
+func (f *Fake) CommandRules(guildID string) (panicbot.CommandRules, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.guild(guildID)
+	if err != nil {
+		return panicbot.CommandRules{}, err
+	}
+	return g.rules, nil
+}
+
+func (f *Fake) BanUser(guildID, userID string, reason string, days int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["BanUser"]; err != nil {
+		return err
+	}
+	g, err := f.guild(guildID)
+	if err != nil {
+		return err
+	}
+	delete(g.members, userID)
+	f.bans = append(f.bans, Ban{GuildID: guildID, UserID: userID, Reason: reason, Days: days})
+	return nil
+}
+
+func (f *Fake) SendChannelMessage(guildID, channelID string, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["SendChannelMessage"]; err != nil {
+		return err
+	}
+	g, err := f.guild(guildID)
+	if err != nil {
+		return err
+	}
+	if channelID == "" {
+		channelID = g.primaryChannelID
+	}
+	f.channelMessages = append(f.channelMessages, ChannelMessage{GuildID: guildID, ChannelID: channelID, Message: message})
+	return nil
+}
+
+func (f *Fake) SendDMEmbed(userID, content, description, titleText, buttonLabel, buttonID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["SendDMEmbed"]; err != nil {
+		return err
+	}
+	f.embeds = append(f.embeds, Embed{
+		UserID:      userID,
+		Content:     content,
+		Description: description,
+		Title:       titleText,
+		ButtonLabel: buttonLabel,
+		ButtonID:    buttonID,
+	})
+	return nil
+}
+
+func (f *Fake) SendDM(userID string, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["SendDM"]; err != nil {
+		return err
+	}
+	f.dms = append(f.dms, DM{UserID: userID, Message: message})
+	return nil
+}
+
+func (f *Fake) GetAllGuildMembers(guildID string) ([]panicbot.UserRoles, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["GetAllGuildMembers"]; err != nil {
+		return nil, err
+	}
+	g, err := f.guild(guildID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]panicbot.UserRoles, 0, len(g.members))
+	for _, member := range g.members {
+		members = append(members, member.UserRoles)
+	}
+	return members, nil
+}
+
+func (f *Fake) GetGuildMemberUsername(guildID, userID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.errors["GetGuildMemberUsername"]; err != nil {
+		return "", err
+	}
+	g, err := f.guild(guildID)
+	if err != nil {
+		return "", err
+	}
+	member, ok := g.members[userID]
+	if !ok {
+		return "", fmt.Errorf("user %s is not a member of guild %s", userID, guildID)
+	}
+	return member.Username, nil
+}
+
+func (f *Fake) UpdateCommandRules(guildID string, rules panicbot.CommandRules) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g, err := f.guild(guildID)
+	if err != nil {
+		return err
+	}
+	g.rules = rules
+	return nil
+}
+
+func (f *Fake) GuildIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.guildOrder...)
+}
+
+// Ready fails until the Fake was started and once it was closed.
+func (f *Fake) Ready() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.started {
+		return fmt.Errorf("not started")
+	}
+	if f.closed {
+		return fmt.Errorf("closed")
+	}
+	return nil
+}
+
+// Start sends the greeting to every guild like DiscordImpl does.
+func (f *Fake) Start(ctx context.Context) error {
+	f.mu.Lock()
+	if f.started {
+		f.mu.Unlock()
+		return fmt.Errorf("Discord was already started")
+	}
+	f.started = true
+	f.mu.Unlock()
+	return f.sendToEveryGuild(ctx, f.greeting)
+}
+
+// Stop turns down every following injected command and sends the farewell to every guild like DiscordImpl does.
+func (f *Fake) Stop(ctx context.Context) error {
+	f.StopAcceptingCommands()
+	return f.sendToEveryGuild(ctx, f.farewell)
+}
+
+func (f *Fake) sendToEveryGuild(ctx context.Context, message string) error {
+	if message == "" {
+		return nil
+	}
+	for _, guildID := range f.GuildIDs() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := f.SendChannelMessage(guildID, "", message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fake) StopAcceptingCommands() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closing = true
+}
+
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closing = true
+	f.closed = true
+	return nil
+}
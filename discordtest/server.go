@@ -0,0 +1,614 @@
+package discordtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+// BOT_USER_ID is the ID of the bot user the Server identifies every session as.
+const BOT_USER_ID = "100000000000000000"
+
+// Message is a message posted to a channel of the Server, including direct message channels.
+type Message struct {
+	ID         string                    `json:"id"`
+	ChannelID  string                    `json:"channel_id"`
+	Content    string                    `json:"content"`
+	Embeds     []*discordgo.MessageEmbed `json:"embeds,omitempty"`
+	Components json.RawMessage           `json:"components,omitempty"`
+}
+
+// InteractionReply is the response of the bot to an interaction. Content is replaced when the response is edited.
+type InteractionReply struct {
+	InteractionID string
+	Token         string
+	Type          discordgo.InteractionResponseType
+	Flags         uint64
+	Content       string
+}
+
+// Server is a local stand-in for the Discord API. It serves guilds, members, roles, channels, bans, messages, slash
+// commands and interaction replies from memory, and a gateway that identifies every session as the bot user
+// BOT_USER_ID and over which events such as slash commands can be dispatched. Point DiscordImplArgs.APIBaseURL at URL
+// to run DiscordImpl against it.
+type Server struct {
+	URL    string
+	server *httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	guilds   map[string]*discordgo.Guild
+	members  map[string]map[string]*discordgo.Member
+	roles    map[string][]*discordgo.Role
+	channels map[string]*discordgo.Channel
+	bans     map[string]map[string]*discordgo.GuildBan
+	messages []Message
+	commands map[string][]*discordgo.ApplicationCommand
+	replies  []InteractionReply
+	requests []string
+
+	// gatewayMu guards the open gateway connections and the sequence number of the events sent over them.
+	gatewayMu sync.Mutex
+	conns     map[*websocket.Conn]bool
+	seq       int64
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		nextID:   1,
+		guilds:   make(map[string]*discordgo.Guild),
+		members:  make(map[string]map[string]*discordgo.Member),
+		roles:    make(map[string][]*discordgo.Role),
+		channels: make(map[string]*discordgo.Channel),
+		bans:     make(map[string]map[string]*discordgo.GuildBan),
+		commands: make(map[string][]*discordgo.ApplicationCommand),
+		conns:    make(map[*websocket.Conn]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/", s.handleGateway)
+	mux.HandleFunc("/api/v"+discordgo.APIVersion+"/", s.handleAPI)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// id returns a new snowflake like ID. s.mu must be held.
+func (s *Server) id() string {
+	s.nextID++
+	return strconv.Itoa(200000000000000000 + s.nextID)
+}
+
+// AddGuild adds a guild. Its @everyone role, which shares the ID of the guild, is added with the given permissions.
+func (s *Server) AddGuild(guildID, name string, everyonePermissions int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guilds[guildID] = &discordgo.Guild{ID: guildID, Name: name}
+	s.members[guildID] = make(map[string]*discordgo.Member)
+	s.bans[guildID] = make(map[string]*discordgo.GuildBan)
+	s.roles[guildID] = append(s.roles[guildID], &discordgo.Role{ID: guildID, Name: "@everyone", Permissions: everyonePermissions})
+}
+
+// AddRole adds a role to the guild.
+func (s *Server) AddRole(guildID string, role *discordgo.Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[guildID] = append(s.roles[guildID], role)
+}
+
+// AddMember adds a member to the guild. member.User must be set.
+func (s *Server) AddMember(guildID string, member *discordgo.Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	member.GuildID = guildID
+	s.members[guildID][member.User.ID] = member
+}
+
+// AddChannel adds a channel. Channels with a GuildID are listed as channels of that guild.
+func (s *Server) AddChannel(channel *discordgo.Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[channel.ID] = channel
+}
+
+// AddCommand registers a slash command in the guild, e.g. one left behind by an older version of the bot. The
+// command is given a new ID.
+func (s *Server) AddCommand(guildID string, command *discordgo.ApplicationCommand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	command.ID = s.id()
+	command.ApplicationID = BOT_USER_ID
+	s.commands[guildID] = append(s.commands[guildID], command)
+}
+
+// Messages returns the messages posted so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Bans returns the bans of the guild.
+func (s *Server) Bans(guildID string) []*discordgo.GuildBan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bans := make([]*discordgo.GuildBan, 0, len(s.bans[guildID]))
+	for _, ban := range s.bans[guildID] {
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].User.ID < bans[j].User.ID })
+	return bans
+}
+
+// Commands returns the slash commands registered in the guild.
+func (s *Server) Commands(guildID string) []*discordgo.ApplicationCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*discordgo.ApplicationCommand(nil), s.commands[guildID]...)
+}
+
+// Replies returns the responses of the bot to interactions so far.
+func (s *Server) Replies() []InteractionReply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]InteractionReply(nil), s.replies...)
+}
+
+// Requests returns the method, path and query of every API request received so far, e.g.
+// "GET /guilds/1/members?limit=1000".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers like the Discord API does for a failed request.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{"code": 0, "message": message})
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v"+discordgo.APIVersion)
+	request := r.Method + " " + path
+	if r.URL.RawQuery != "" {
+		request += "?" + r.URL.RawQuery
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && path == "/gateway":
+		writeJSON(w, http.StatusOK, map[string]string{"url": "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/"})
+	case len(parts) >= 2 && parts[0] == "guilds":
+		s.handleGuild(w, r, parts[1], parts[2:])
+	case len(parts) >= 2 && parts[0] == "channels":
+		s.handleChannel(w, r, parts[1], parts[2:])
+	case r.Method == http.MethodGet && path == "/users/@me":
+		writeJSON(w, http.StatusOK, &discordgo.User{ID: BOT_USER_ID, Username: "panicbot", Discriminator: "0000", Bot: true})
+	case r.Method == http.MethodPost && path == "/users/@me/channels":
+		s.createDMChannel(w, r)
+	case len(parts) == 5 && parts[0] == "applications" && parts[2] == "guilds" && parts[4] == "commands":
+		s.handleCommands(w, r, parts[3])
+	case r.Method == http.MethodDelete && len(parts) == 6 && parts[0] == "applications" && parts[2] == "guilds" && parts[4] == "commands":
+		s.deleteCommand(w, parts[3], parts[5])
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[0] == "interactions" && parts[3] == "callback":
+		s.reply(w, r, parts[1], parts[2])
+	case r.Method == http.MethodPatch && len(parts) == 5 && parts[0] == "webhooks" && parts[3] == "messages" && parts[4] == "@original":
+		s.editReply(w, r, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not served by discordtest", r.Method, path))
+	}
+}
+
+func (s *Server) handleGuild(w http.ResponseWriter, r *http.Request, guildID string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guild, ok := s.guilds[guildID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown Guild")
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && len(rest) == 0:
+		writeJSON(w, http.StatusOK, guild)
+	case r.Method == http.MethodGet && len(rest) == 1 && rest[0] == "members":
+		writeJSON(w, http.StatusOK, s.listMembers(guildID, r.URL.Query().Get("after"), r.URL.Query().Get("limit")))
+	case r.Method == http.MethodGet && len(rest) == 2 && rest[0] == "members":
+		member, ok := s.members[guildID][rest[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Unknown Member")
+			return
+		}
+		writeJSON(w, http.StatusOK, member)
+	case r.Method == http.MethodGet && len(rest) == 1 && rest[0] == "roles":
+		writeJSON(w, http.StatusOK, s.roles[guildID])
+	case r.Method == http.MethodGet && len(rest) == 1 && rest[0] == "channels":
+		channels := make([]*discordgo.Channel, 0)
+		for _, channel := range s.channels {
+			if channel.GuildID == guildID {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Slice(channels, func(i, j int) bool { return channels[i].Position < channels[j].Position })
+		writeJSON(w, http.StatusOK, channels)
+	case r.Method == http.MethodPut && len(rest) == 2 && rest[0] == "bans":
+		s.ban(w, r, guildID, rest[1])
+	case r.Method == http.MethodGet && len(rest) == 2 && rest[0] == "bans":
+		ban, ok := s.bans[guildID][rest[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Unknown Ban")
+			return
+		}
+		writeJSON(w, http.StatusOK, ban)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not served by discordtest", r.Method, r.URL.Path))
+	}
+}
+
+// listMembers returns up to limit members ordered by user ID, starting after the given user ID. s.mu must be held.
+func (s *Server) listMembers(guildID, after, limit string) []*discordgo.Member {
+	max, err := strconv.Atoi(limit)
+	if err != nil || max <= 0 {
+		max = 1
+	}
+	members := make([]*discordgo.Member, 0, len(s.members[guildID]))
+	for _, member := range s.members[guildID] {
+		members = append(members, member)
+	}
+	// Discord orders members by their snowflake, which sorts like a number.
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i].User.ID, members[j].User.ID
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	page := make([]*discordgo.Member, 0, max)
+	for _, member := range members {
+		id := member.User.ID
+		if after != "" && (len(id) < len(after) || (len(id) == len(after) && id <= after)) {
+			continue
+		}
+		if len(page) == max {
+			break
+		}
+		page = append(page, member)
+	}
+	return page
+}
+
+// ban bans the user and removes them from the guild. s.mu must be held.
+func (s *Server) ban(w http.ResponseWriter, r *http.Request, guildID, userID string) {
+	user := &discordgo.User{ID: userID}
+	if member, ok := s.members[guildID][userID]; ok {
+		user = member.User
+		delete(s.members[guildID], userID)
+	}
+	// discordgo sends the reason as a query parameter, newer clients use the X-Audit-Log-Reason header.
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = r.Header.Get("X-Audit-Log-Reason")
+	}
+	s.bans[guildID][userID] = &discordgo.GuildBan{
+		Reason: reason,
+		User:   user,
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleChannel(w http.ResponseWriter, r *http.Request, channelID string, rest []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel, ok := s.channels[channelID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unknown Channel")
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && len(rest) == 0:
+		writeJSON(w, http.StatusOK, channel)
+	case r.Method == http.MethodPost && len(rest) == 1 && rest[0] == "messages":
+		message := Message{}
+		err := json.NewDecoder(r.Body).Decode(&message)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid message: %s", err.Error()))
+			return
+		}
+		message.ID = s.id()
+		message.ChannelID = channelID
+		s.messages = append(s.messages, message)
+		writeJSON(w, http.StatusOK, message)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not served by discordtest", r.Method, r.URL.Path))
+	}
+}
+
+// createDMChannel returns the direct message channel with a user, creating it on first use.
+func (s *Server) createDMChannel(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		RecipientID string `json:"recipient_id"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil || data.RecipientID == "" {
+		writeError(w, http.StatusBadRequest, "recipient_id is required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channelID := "dm-" + data.RecipientID
+	channel, ok := s.channels[channelID]
+	if !ok {
+		channel = &discordgo.Channel{
+			ID:         channelID,
+			Type:       discordgo.ChannelTypeDM,
+			Recipients: []*discordgo.User{{ID: data.RecipientID}},
+		}
+		s.channels[channelID] = channel
+	}
+	writeJSON(w, http.StatusOK, channel)
+}
+
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request, guildID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.commands[guildID])
+	case http.MethodPost:
+		command := &discordgo.ApplicationCommand{}
+		err := json.NewDecoder(r.Body).Decode(command)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid command: %s", err.Error()))
+			return
+		}
+		command.ID = s.id()
+		command.ApplicationID = BOT_USER_ID
+		// Creating a command with the name of an existing one replaces it.
+		commands := make([]*discordgo.ApplicationCommand, 0, len(s.commands[guildID])+1)
+		for _, existing := range s.commands[guildID] {
+			if existing.Name != command.Name {
+				commands = append(commands, existing)
+			}
+		}
+		s.commands[guildID] = append(commands, command)
+		writeJSON(w, http.StatusCreated, command)
+	case http.MethodPut:
+		commands := make([]*discordgo.ApplicationCommand, 0)
+		err := json.NewDecoder(r.Body).Decode(&commands)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid commands: %s", err.Error()))
+			return
+		}
+		// Like Discord, commands that keep their name keep their ID.
+		ids := make(map[string]string, len(s.commands[guildID]))
+		for _, existing := range s.commands[guildID] {
+			ids[existing.Name] = existing.ID
+		}
+		for _, command := range commands {
+			command.ID = ids[command.Name]
+			if command.ID == "" {
+				command.ID = s.id()
+			}
+			command.ApplicationID = BOT_USER_ID
+		}
+		s.commands[guildID] = commands
+		writeJSON(w, http.StatusOK, commands)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) deleteCommand(w http.ResponseWriter, guildID, commandID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands := make([]*discordgo.ApplicationCommand, 0, len(s.commands[guildID]))
+	found := false
+	for _, command := range s.commands[guildID] {
+		if command.ID == commandID {
+			found = true
+			continue
+		}
+		commands = append(commands, command)
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "Unknown application command")
+		return
+	}
+	s.commands[guildID] = commands
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reply(w http.ResponseWriter, r *http.Request, interactionID, token string) {
+	response := struct {
+		Type discordgo.InteractionResponseType `json:"type"`
+		Data *struct {
+			Content string `json:"content"`
+			Flags   uint64 `json:"flags"`
+		} `json:"data"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&response)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid interaction response: %s", err.Error()))
+		return
+	}
+	reply := InteractionReply{InteractionID: interactionID, Token: token, Type: response.Type}
+	if response.Data != nil {
+		reply.Content = response.Data.Content
+		reply.Flags = response.Data.Flags
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.replies {
+		if existing.Token == token {
+			writeError(w, http.StatusBadRequest, "Interaction has already been acknowledged.")
+			return
+		}
+	}
+	s.replies = append(s.replies, reply)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) editReply(w http.ResponseWriter, r *http.Request, token string) {
+	edit := struct {
+		Content string `json:"content"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&edit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid message: %s", err.Error()))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.replies {
+		if s.replies[i].Token == token {
+			s.replies[i].Content = edit.Content
+			writeJSON(w, http.StatusOK, Message{ID: s.id(), Content: edit.Content})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Unknown Webhook")
+}
+
+// Dispatch sends an event, e.g. INTERACTION_CREATE, to every session connected to the gateway.
+func (s *Server) Dispatch(eventType string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+	s.gatewayMu.Lock()
+	defer s.gatewayMu.Unlock()
+	if len(s.conns) == 0 {
+		return fmt.Errorf("no session is connected to the gateway")
+	}
+	s.seq++
+	for conn := range s.conns {
+		err = conn.WriteJSON(gatewayPayload{Op: 0, Seq: s.seq, Type: eventType, Data: raw})
+		if err != nil {
+			return fmt.Errorf("failed to send %s event: %w", eventType, err)
+		}
+	}
+	return nil
+}
+
+// SlashCommand dispatches the use of a slash command by member in the guild and returns the token of the
+// interaction, which identifies the reply of the bot in Replies.
+func (s *Server) SlashCommand(guildID string, member *discordgo.Member, name string, options ...*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	s.mu.Lock()
+	interactionID := s.id()
+	token := "token-" + interactionID
+	s.mu.Unlock()
+	return token, s.Dispatch("INTERACTION_CREATE", map[string]any{
+		"id":             interactionID,
+		"application_id": BOT_USER_ID,
+		"type":           discordgo.InteractionApplicationCommand,
+		"guild_id":       guildID,
+		"channel_id":     guildID,
+		"member":         member,
+		"token":          token,
+		"version":        1,
+		"data": map[string]any{
+			"id":      interactionID,
+			"name":    name,
+			"type":    discordgo.ChatApplicationCommand,
+			"options": options,
+		},
+	})
+}
+
+// gatewayPayload is a message sent over the gateway.
+type gatewayPayload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d,omitempty"`
+	Seq  int64           `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+// handleGateway speaks just enough of the gateway protocol for discordgo to connect: Hello, Ready after Identify
+// and an Ack for every heartbeat.
+func (s *Server) handleGateway(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		s.gatewayMu.Lock()
+		delete(s.conns, conn)
+		s.gatewayMu.Unlock()
+		conn.Close()
+	}()
+
+	// Writes are serialized with Dispatch through gatewayMu.
+	write := func(payload gatewayPayload) error {
+		s.gatewayMu.Lock()
+		defer s.gatewayMu.Unlock()
+		if payload.Op == 0 {
+			s.seq++
+			payload.Seq = s.seq
+		}
+		return conn.WriteJSON(payload)
+	}
+	err = write(gatewayPayload{Op: 10, Data: json.RawMessage(`{"heartbeat_interval":45000}`)})
+	if err != nil {
+		return
+	}
+	for {
+		payload := gatewayPayload{}
+		err := conn.ReadJSON(&payload)
+		if err != nil {
+			return
+		}
+		switch payload.Op {
+		case 1:
+			err = write(gatewayPayload{Op: 11})
+		case 2:
+			// The session is registered together with Ready so that events dispatched once Open returned reach it.
+			ready := s.ready()
+			s.gatewayMu.Lock()
+			s.seq++
+			err = conn.WriteJSON(gatewayPayload{Op: 0, Seq: s.seq, Type: "READY", Data: ready})
+			s.conns[conn] = true
+			s.gatewayMu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ready builds the data of the READY event, every guild is reported as unavailable like Discord does.
+func (s *Server) ready() json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guilds := make([]map[string]any, 0, len(s.guilds))
+	for guildID := range s.guilds {
+		guilds = append(guilds, map[string]any{"id": guildID, "unavailable": true})
+	}
+	data, _ := json.Marshal(map[string]any{
+		"v":          9,
+		"session_id": "discordtest",
+		"user":       map[string]any{"id": BOT_USER_ID, "username": "panicbot", "discriminator": "0000", "bot": true},
+		"guilds":     guilds,
+	})
+	return data
+}
@@ -0,0 +1,308 @@
+package panicbot
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot/internal/metrics"
+)
+
+const (
+	// EmailSecurityStartTLS connects in plain text and upgrades the connection with STARTTLS. Usually port 587.
+	EmailSecurityStartTLS = "starttls"
+	// EmailSecurityTLS connects over TLS from the start (implicit TLS). Usually port 465.
+	EmailSecurityTLS = "tls"
+)
+
+type Email interface {
+	SendMail(to []string, subject, body string) error
+	SendHTMLMail(to []string, subject, textBody, htmlBody string) error
+	// CheckCredentials verifies that the SMTP server can be reached and accepts the configured credentials.
+	CheckCredentials() error
+}
+type EmailImpl struct {
+	identity  string
+	username  string
+	password  string
+	host      string
+	hostname  string
+	from      string
+	security  string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	logger    *log.Logger
+}
+type EmailImplArgs struct {
+	Identity string
+	Username string
+	Password string
+	// Host is the address of the SMTP server including the port, e.g. smtp.example.com:587
+	Host string
+	From string
+	// Security is either EmailSecurityStartTLS or EmailSecurityTLS. Defaults to EmailSecurityStartTLS.
+	Security string
+	// TLSConfig is optional. It allows a custom certificate pool to be used, for example when sending to a local server.
+	TLSConfig *tls.Config
+	// Timeout is the time allowed to connect to the server. Defaults to thirty seconds.
+	Timeout time.Duration
+	Logger  *log.Logger
+}
+
+var _ Email = (*EmailImpl)(nil)
+
+func (e *EmailImpl) SendMail(to []string, subject, body string) error {
+	header := e.header(to, subject)
+	header.Set("Content-Type", "text/plain; charset=UTF-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	msg := &bytes.Buffer{}
+	writeHeader(msg, header)
+	err := writeQuotedPrintable(msg, body)
+	if err != nil {
+		return fmt.Errorf("failed to encode email body: %w", err)
+	}
+	return e.send(to, msg.Bytes())
+}
+
+func (e *EmailImpl) SendHTMLMail(to []string, subject, textBody, htmlBody string) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	header := e.header(to, subject)
+	header.Set("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", writer.Boundary()))
+
+	// Parts are ordered from least to most preferred as required by multipart/alternative.
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/plain; charset=UTF-8", content: textBody},
+		{contentType: "text/html; charset=UTF-8", content: htmlBody},
+	}
+	for _, part := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create email part: %w", err)
+		}
+		err = writeQuotedPrintable(w, part.content)
+		if err != nil {
+			return fmt.Errorf("failed to encode email body: %w", err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return fmt.Errorf("failed to finish email body: %w", err)
+	}
+
+	msg := &bytes.Buffer{}
+	writeHeader(msg, header)
+	msg.Write(body.Bytes())
+	return e.send(to, msg.Bytes())
+}
+
+func (e *EmailImpl) header(to []string, subject string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set("From", e.from)
+	header.Set("To", strings.Join(to, ", "))
+	header.Set("Subject", mime.QEncoding.Encode("UTF-8", subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+	return header
+}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	// Keep a stable order so that the messages are easy to read in logs and tests.
+	for _, key := range []string{"From", "To", "Subject", "Date", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"} {
+		if value := header.Get(key); value != "" {
+			fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(content))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func (e *EmailImpl) send(to []string, msg []byte) (err error) {
+	defer func() {
+		metrics.EmailSends.WithLabelValues(metrics.Result(err)).Inc()
+	}()
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients were given")
+	}
+	client, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = client.Mail(e.from)
+	if err != nil {
+		return fmt.Errorf("failed to set sender %s: %w", e.from, err)
+	}
+	for _, address := range to {
+		err = client.Rcpt(address)
+		if err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start email data: %w", err)
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return fmt.Errorf("failed to write email data: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", strings.Join(to, ", "), err)
+	}
+	err = client.Quit()
+	if err != nil {
+		e.logger.Warnf("failed to close connection to SMTP server: %s", err.Error())
+	}
+
+	e.logger.WithFields(log.Fields{
+		"to": strings.Join(to, ", "),
+	}).Debug("Sent email")
+	return nil
+}
+
+func (e *EmailImpl) CheckCredentials() error {
+	client, err := e.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	err = client.Quit()
+	if err != nil {
+		return fmt.Errorf("failed to close connection to SMTP server %s: %w", e.host, err)
+	}
+	return nil
+}
+
+// connect dials the SMTP server, starts TLS if needed and authenticates.
+func (e *EmailImpl) connect() (*smtp.Client, error) {
+	client, err := e.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.security == EmailSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", e.host)
+		}
+		err = client.StartTLS(e.tlsConfig)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to start TLS with SMTP server %s: %w", e.host, err)
+		}
+	}
+
+	if e.username != "" {
+		err = client.Auth(smtp.PlainAuth(e.identity, e.username, e.password, e.hostname))
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to authenticate with SMTP server %s: %w", e.host, err)
+		}
+	}
+	return client, nil
+}
+
+func (e *EmailImpl) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: e.timeout}
+	var conn net.Conn
+	var err error
+	if e.security == EmailSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.host, e.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", e.host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server %s: %w", e.host, err)
+	}
+	client, err := smtp.NewClient(conn, e.hostname)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to greet SMTP server %s: %w", e.host, err)
+	}
+	return client, nil
+}
+
+func NewEmail(args *EmailImplArgs) (*EmailImpl, error) {
+	if args.Host == "" {
+		return nil, fmt.Errorf("Host cannot be empty. Did you forget to set it in the config?")
+	}
+	hostname, _, err := net.SplitHostPort(args.Host)
+	if err != nil {
+		return nil, fmt.Errorf("Host must contain the address and the port of the SMTP server, e.g. smtp.example.com:587: %w", err)
+	}
+
+	if args.From == "" {
+		return nil, fmt.Errorf("From cannot be empty. Did you forget to set it in the config?")
+	}
+	if args.Username != "" && args.Password == "" {
+		return nil, fmt.Errorf("Password cannot be empty when Username is set. Did you forget to set it in the config?")
+	}
+
+	security := strings.ToLower(args.Security)
+	if security == "" {
+		security = EmailSecurityStartTLS
+	}
+	if security != EmailSecurityStartTLS && security != EmailSecurityTLS {
+		return nil, fmt.Errorf("Security must be either %s or %s, got: %s", EmailSecurityStartTLS, EmailSecurityTLS, args.Security)
+	}
+
+	if args.Logger == nil {
+		return nil, fmt.Errorf("logger was not initialized: %+v", args.Logger)
+	}
+
+	tlsConfig := &tls.Config{}
+	if args.TLSConfig != nil {
+		tlsConfig = args.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = hostname
+	}
+
+	timeout := args.Timeout
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
+
+	emailImpl := &EmailImpl{
+		identity:  args.Identity,
+		username:  args.Username,
+		password:  args.Password,
+		host:      args.Host,
+		hostname:  hostname,
+		from:      args.From,
+		security:  security,
+		tlsConfig: tlsConfig,
+		timeout:   timeout,
+		logger:    args.Logger,
+	}
+	return emailImpl, nil
+}
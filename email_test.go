@@ -0,0 +1,352 @@
+package panicbot
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// smtpMessage is a message received by smtpStub.
+type smtpMessage struct {
+	From string
+	To   []string
+	Data []byte
+	// TLS is whether the message was sent over an encrypted connection.
+	TLS bool
+	// Auth holds the identity, username and password of the AUTH PLAIN command.
+	Auth []string
+}
+
+// smtpStub is a minimal SMTP server that speaks just enough of the protocol for net/smtp. It offers STARTTLS unless
+// implicitTLS is set, and accepts AUTH PLAIN with the given username and password.
+type smtpStub struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	implicitTLS bool
+	noStartTLS  bool
+	username    string
+	password    string
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+// newSMTPStub starts an smtpStub and returns it with a TLS config that trusts its certificate.
+func newSMTPStub(t *testing.T, implicitTLS bool) (*smtpStub, *tls.Config) {
+	t.Helper()
+	// httptest provides a certificate that is valid for 127.0.0.1.
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	cert := certServer.TLS.Certificates[0]
+	pool := x509.NewCertPool()
+	pool.AddCert(certServer.Certificate())
+	certServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	s := &smtpStub{
+		listener:    listener,
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		implicitTLS: implicitTLS,
+		username:    "bot",
+		password:    "secret",
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s, &tls.Config{RootCAs: pool}
+}
+
+func (s *smtpStub) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *smtpStub) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStub) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	encrypted := false
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tlsConfig)
+		encrypted = true
+	}
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		io.WriteString(conn, strings.Join(lines, "\r\n")+"\r\n")
+	}
+	authenticated := false
+	msg := smtpMessage{}
+
+	reply("220 localhost ESMTP smtpStub")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if !encrypted && !s.noStartTLS {
+				reply("250-localhost", "250-STARTTLS", "250 AUTH PLAIN")
+				continue
+			}
+			reply("250-localhost", "250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 Ready to start TLS")
+			conn = tls.Server(conn, s.tlsConfig)
+			r = bufio.NewReader(conn)
+			encrypted = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if !strings.EqualFold(mechanism, "PLAIN") || err != nil {
+				reply("504 Unrecognized authentication type")
+				continue
+			}
+			msg.Auth = strings.Split(string(decoded), "\x00")
+			if len(msg.Auth) != 3 || msg.Auth[1] != s.username || msg.Auth[2] != s.password {
+				reply("535 Authentication credentials invalid")
+				continue
+			}
+			authenticated = true
+			reply("235 Authentication successful")
+		case "MAIL":
+			if !authenticated {
+				reply("530 Authentication required")
+				continue
+			}
+			msg.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data := &strings.Builder{}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			// The line break before the terminating dot belongs to the terminator.
+			msg.Data = []byte(strings.TrimSuffix(data.String(), "\r\n"))
+			msg.TLS = encrypted
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = smtpMessage{Auth: msg.Auth}
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func newTestEmail(t *testing.T, s *smtpStub, tlsConfig *tls.Config, security, password string) *EmailImpl {
+	t.Helper()
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	e, err := NewEmail(&EmailImplArgs{
+		Username:  s.username,
+		Password:  password,
+		Host:      s.addr(),
+		From:      "panicbot@example.com",
+		Security:  security,
+		TLSConfig: tlsConfig,
+		Logger:    logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create email: %s", err.Error())
+	}
+	return e
+}
+
+// decodeQuotedPrintable decodes a text body. Line breaks are sent as CRLF and returned as LF.
+func decodeQuotedPrintable(t *testing.T, r io.Reader) string {
+	t.Helper()
+	body, err := io.ReadAll(quotedprintable.NewReader(r))
+	if err != nil {
+		t.Fatalf("failed to decode quoted-printable body: %s", err.Error())
+	}
+	return strings.ReplaceAll(string(body), "\r\n", "\n")
+}
+
+// A long line with non ASCII characters so that the body has to be encoded and wrapped.
+var testEmailBody = "Panic alert 🚨 from <@1234>: " + strings.Repeat("everyone please check the voice channel ", 5) + "\nSecond line."
+
+func TestEmailSendMailStartTLS(t *testing.T) {
+	s, tlsConfig := newSMTPStub(t, false)
+	e := newTestEmail(t, s, tlsConfig, EmailSecurityStartTLS, s.password)
+
+	err := e.SendMail([]string{"admin@example.com", "mod@example.com"}, "Panic Alert ✔", testEmailBody)
+	if err != nil {
+		t.Fatalf("SendMail failed: %s", err.Error())
+	}
+
+	received := s.received()
+	if len(received) != 1 {
+		t.Fatalf("expected one message, got %d", len(received))
+	}
+	got := received[0]
+	if !got.TLS {
+		t.Errorf("expected the message to be sent after STARTTLS")
+	}
+	if strings.Join(got.Auth, ",") != ",bot,secret" {
+		t.Errorf("unexpected AUTH PLAIN credentials: %q", got.Auth)
+	}
+	if got.From != "panicbot@example.com" || strings.Join(got.To, ",") != "admin@example.com,mod@example.com" {
+		t.Errorf("unexpected envelope: from %s to %v", got.From, got.To)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(got.Data)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %s", err.Error())
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Panic Alert ✔" {
+		t.Errorf("unexpected subject %q: %v", subject, err)
+	}
+	if msg.Header.Get("Content-Type") != "text/plain; charset=UTF-8" {
+		t.Errorf("unexpected content type: %s", msg.Header.Get("Content-Type"))
+	}
+	if msg.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Errorf("unexpected transfer encoding: %s", msg.Header.Get("Content-Transfer-Encoding"))
+	}
+	if body := decodeQuotedPrintable(t, msg.Body); body != testEmailBody {
+		t.Errorf("unexpected body:\n%s\nwant:\n%s", body, testEmailBody)
+	}
+}
+
+func TestEmailSendHTMLMailImplicitTLS(t *testing.T) {
+	s, tlsConfig := newSMTPStub(t, true)
+	e := newTestEmail(t, s, tlsConfig, EmailSecurityTLS, s.password)
+
+	htmlBody := "<p>" + testEmailBody + "</p>"
+	err := e.SendHTMLMail([]string{"admin@example.com"}, "Panic Alert", testEmailBody, htmlBody)
+	if err != nil {
+		t.Fatalf("SendHTMLMail failed: %s", err.Error())
+	}
+
+	received := s.received()
+	if len(received) != 1 {
+		t.Fatalf("expected one message, got %d", len(received))
+	}
+	if !received[0].TLS {
+		t.Errorf("expected the message to be sent over implicit TLS")
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(received[0].Data)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %s", err.Error())
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative, got %s: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	want := []struct {
+		contentType string
+		body        string
+	}{
+		{contentType: "text/plain; charset=UTF-8", body: testEmailBody},
+		{contentType: "text/html; charset=UTF-8", body: htmlBody},
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for i, w := range want {
+		part, err := reader.NextRawPart()
+		if err != nil {
+			t.Fatalf("failed to read part %d: %s", i, err.Error())
+		}
+		if part.Header.Get("Content-Type") != w.contentType {
+			t.Errorf("part %d: expected content type %s, got %s", i, w.contentType, part.Header.Get("Content-Type"))
+		}
+		if part.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+			t.Errorf("part %d: unexpected transfer encoding %s", i, part.Header.Get("Content-Transfer-Encoding"))
+		}
+		if body := decodeQuotedPrintable(t, part); body != w.body {
+			t.Errorf("part %d: unexpected body:\n%s\nwant:\n%s", i, body, w.body)
+		}
+	}
+	if _, err := reader.NextRawPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got error %v", err)
+	}
+}
+
+func TestEmailCheckCredentials(t *testing.T) {
+	for _, security := range []string{EmailSecurityStartTLS, EmailSecurityTLS} {
+		t.Run(security, func(t *testing.T) {
+			s, tlsConfig := newSMTPStub(t, security == EmailSecurityTLS)
+
+			err := newTestEmail(t, s, tlsConfig, security, s.password).CheckCredentials()
+			if err != nil {
+				t.Errorf("expected valid credentials to pass: %s", err.Error())
+			}
+			err = newTestEmail(t, s, tlsConfig, security, "wrong").CheckCredentials()
+			if err == nil {
+				t.Errorf("expected a wrong password to fail")
+			}
+		})
+	}
+}
+
+func TestEmailStartTLSRequired(t *testing.T) {
+	s, tlsConfig := newSMTPStub(t, false)
+	s.noStartTLS = true
+	e := newTestEmail(t, s, tlsConfig, EmailSecurityStartTLS, s.password)
+
+	err := e.SendMail([]string{"admin@example.com"}, "Panic Alert", "body")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected sending without STARTTLS to fail, got %v", err)
+	}
+	if len(s.received()) != 0 {
+		t.Errorf("expected no message to be sent in plain text")
+	}
+}
+
+func TestEmailUntrustedCertificate(t *testing.T) {
+	s, _ := newSMTPStub(t, true)
+	e := newTestEmail(t, s, nil, EmailSecurityTLS, s.password)
+
+	err := e.SendMail([]string{"admin@example.com"}, "Panic Alert", "body")
+	if err == nil {
+		t.Fatalf("expected a certificate that is not trusted to be rejected")
+	}
+}
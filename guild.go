@@ -0,0 +1,65 @@
+package panicbot
+
+import (
+	"fmt"
+	"sync"
+)
+
+// GuildArgs configures one of the guilds served by DiscordImpl.
+type GuildArgs struct {
+	GuildID string
+	// PrimaryChannelID is optional. The channel the bot reports to, found from the guild when empty.
+	PrimaryChannelID string
+	Rules            CommandRules
+}
+
+// guild holds the settings and the command state of one guild. Cooldowns, rate limits and unauthorized use are
+// tracked separately for every guild.
+type guild struct {
+	id               string
+	primaryChannelID string
+	rulesMu          sync.RWMutex
+	rules            CommandRules
+	cooldowns        *cooldownTracker
+	rateLimiter      *rateLimiter
+	abuseTracker     *abuseTracker
+}
+
+func newGuild(args GuildArgs) *guild {
+	return &guild{
+		id:               args.GuildID,
+		primaryChannelID: args.PrimaryChannelID,
+		rules:            args.Rules,
+		cooldowns:        newCooldownTracker(),
+		rateLimiter:      newRateLimiter(),
+		abuseTracker:     newAbuseTracker(),
+	}
+}
+
+func (g *guild) commandRules() CommandRules {
+	g.rulesMu.RLock()
+	defer g.rulesMu.RUnlock()
+	return g.rules
+}
+
+func (g *guild) setCommandRules(rules CommandRules) {
+	g.rulesMu.Lock()
+	defer g.rulesMu.Unlock()
+	g.rules = rules
+}
+
+// guild returns the guild with the given ID. Only guilds passed to NewDiscord are served.
+func (d *DiscordImpl) guild(guildID string) (*guild, error) {
+	g, ok := d.guilds[guildID]
+	if !ok {
+		return nil, fmt.Errorf("guild %s is not configured", guildID)
+	}
+	return g, nil
+}
+
+// GuildIDs returns the IDs of every guild served by the bot.
+func (d *DiscordImpl) GuildIDs() []string {
+	guildIDs := make([]string, 0, len(d.guildOrder))
+	guildIDs = append(guildIDs, d.guildOrder...)
+	return guildIDs
+}
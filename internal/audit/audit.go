@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Events recorded in the audit log.
+const (
+	VoteStarted      = "vote_started"
+	VoteCast         = "vote_cast"
+	VotePassed       = "vote_passed"
+	VoteExpired      = "vote_expired"
+	BanExecuted      = "ban_executed"
+	BanFailed        = "ban_failed"
+	AlertDispatched  = "alert_dispatched"
+	AlertFailed      = "alert_failed"
+	PermissionDenied = "permission_denied"
+	SettingChanged   = "setting_changed"
+)
+
+// Events lists every event, in the order they usually happen.
+var Events = []string{VoteStarted, VoteCast, VotePassed, VoteExpired, BanExecuted, BanFailed, AlertDispatched, AlertFailed, PermissionDenied, SettingChanged}
+
+// Entry is a single line of the audit log.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	GuildID string    `json:"guildID,omitempty"`
+	Event   string    `json:"event"`
+	// Actor is the ID of the user that caused the event, Target is who or what it was aimed at, e.g. the user that
+	// was banned or the phone number that was alerted.
+	Actor   string            `json:"actor,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	Reason  string            `json:"reason,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Log appends entries to a JSON Lines file. The most recent entries are also kept in memory so that they can be
+// queried without reading the file.
+type Log struct {
+	mu        sync.Mutex
+	file      *os.File
+	recent    []Entry
+	maxRecent int
+}
+
+// Open opens the audit log at path, creating it if needed. Entries are only kept in memory when path is empty.
+// maxRecent is how many entries Recent can return.
+func Open(path string, maxRecent int) (*Log, error) {
+	l := &Log{maxRecent: maxRecent}
+	if path == "" {
+		return l, nil
+	}
+	err := l.loadRecent(path)
+	if err != nil {
+		return nil, err
+	}
+	l.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// loadRecent reads the last entries of an existing audit log.
+func (l *Log) loadRecent(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := Entry{}
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		l.remember(entry)
+	}
+	return scanner.Err()
+}
+
+func (l *Log) remember(entry Entry) {
+	l.recent = append(l.recent, entry)
+	if len(l.recent) > l.maxRecent {
+		l.recent = l.recent[len(l.recent)-l.maxRecent:]
+	}
+}
+
+// Record appends entry to the log. Time is set to now when it is zero.
+func (l *Log) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remember(entry)
+	if l.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Recent returns up to limit of the latest entries of the guild, newest first. An empty event matches every event.
+func (l *Log) Recent(guildID, event string, limit int) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]Entry, 0, limit)
+	for i := len(l.recent) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := l.recent[i]
+		if entry.GuildID != guildID || (event != "" && entry.Event != event) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,46 @@
+package baseurl
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Transport sends every request to Base instead of the host it was created for. The path of the original request
+// is appended to the path of Base. It allows clients with hard coded API endpoints to be pointed at a local server.
+type Transport struct {
+	Base *url.URL
+	// Next is the RoundTripper used to send the rewritten request. Defaults to http.DefaultTransport.
+	Next http.RoundTripper
+}
+
+// NewTransport parses base and returns a Transport that sends every request to it.
+func NewTransport(base string) (*Transport, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base URL %s: %w", base, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL %s must contain a scheme and a host", base)
+	}
+	return &Transport{Base: u}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request they were given.
+	rewritten := req.Clone(req.Context())
+	rewritten.URL.Scheme = t.Base.Scheme
+	rewritten.URL.Host = t.Base.Host
+	rewritten.URL.Path = strings.TrimSuffix(t.Base.Path, "/") + req.URL.Path
+	if req.URL.RawPath != "" {
+		rewritten.URL.RawPath = strings.TrimSuffix(t.Base.Path, "/") + req.URL.RawPath
+	}
+	rewritten.Host = t.Base.Host
+
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(rewritten)
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of a slash command.
+const (
+	CommandAccepted      = "accepted"
+	CommandDenied        = "denied"
+	CommandCooldown      = "cooldown"
+	CommandRateLimited   = "rate_limited"
+	CommandFailed        = "error"
+	CommandShuttingDown  = "shutting_down"
+	CommandNotConfigured = "not_configured"
+)
+
+// Results of sending a message.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	SlashCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_slash_commands_total",
+		Help: "Slash commands used, by command and outcome.",
+	}, []string{"command", "outcome"})
+
+	VotesStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_votes_started_total",
+		Help: "Votes started, by panic type.",
+	}, []string{"panic_type"})
+
+	VotesPassed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_votes_passed_total",
+		Help: "Votes that reached the required number of votes, by panic type.",
+	}, []string{"panic_type"})
+
+	VotesExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_votes_expired_total",
+		Help: "Votes that expired before reaching the required number of votes, by panic type.",
+	}, []string{"panic_type"})
+
+	VoteTimeToThreshold = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "panicbot_vote_time_to_threshold_seconds",
+		Help:    "Time from the start of a vote until it reached the required number of votes, by panic type.",
+		Buckets: []float64{5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"panic_type"})
+
+	DMFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_dm_send_failures_total",
+		Help: "Direct messages that could not be sent, by kind of message.",
+	}, []string{"kind"})
+
+	TwilioSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_twilio_sends_total",
+		Help: "Text messages and calls placed through Twilio, by method and result.",
+	}, []string{"method", "result"})
+
+	EmailSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "panicbot_email_sends_total",
+		Help: "Emails sent over SMTP, by result.",
+	}, []string{"result"})
+
+	GatewayReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "panicbot_discord_gateway_reconnects_total",
+		Help: "Times the connection to the Discord gateway was established again after it was lost.",
+	})
+)
+
+// Result returns ResultFailure if err is set and ResultSuccess otherwise.
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,40 @@
+package multierror
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error collects several errors into a single error value.
+type Error struct {
+	Errors []error
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d error(s) occurred: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Append adds the non nil errors to err, creating it if needed.
+func Append(err *Error, errs ...error) *Error {
+	if err == nil {
+		err = &Error{}
+	}
+	for _, e := range errs {
+		if e != nil {
+			err.Errors = append(err.Errors, e)
+		}
+	}
+	return err
+}
+
+// ErrorOrNil returns nil when no errors were collected so that callers can return it directly.
+func (e *Error) ErrorOrNil() error {
+	if e == nil || len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
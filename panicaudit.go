@@ -0,0 +1,69 @@
+package panicbot
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/streemtech/panicbot/internal/metrics"
+)
+
+// PanicAuditRequest is a /panicaudit query of an administrator of a guild.
+type PanicAuditRequest struct {
+	// Event limits the result to one kind of event, every event is returned when empty.
+	Event string
+	// Limit is the number of entries to return.
+	Limit int
+}
+
+// panicAuditCommand builds the /panicaudit command, events are offered as the choices of the event option.
+func panicAuditCommand(events []string) *discordgo.ApplicationCommand {
+	var def bool = false
+	minLimit := float64(1)
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(events))
+	for _, event := range events {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: event, Value: event})
+	}
+	return &discordgo.ApplicationCommand{
+		Name:              "panicaudit",
+		Description:       "Show the most recent panicbot audit log entries of this server. Administrators only.",
+		DefaultPermission: &def,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "event",
+				Description: "Only show entries of this event.",
+				Required:    false,
+				Choices:     choices,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "limit",
+				Description: "Number of entries to show, 10 by default.",
+				Required:    false,
+				MinValue:    &minLimit,
+				MaxValue:    25,
+			},
+		},
+	}
+}
+
+// handlePanicAudit passes a /panicaudit query of an administrator to the PanicAuditCallback and shows its response
+// to the administrator only.
+func (d *DiscordImpl) handlePanicAudit(s *discordgo.Session, i *discordgo.InteractionCreate, g *guild) {
+	if !d.requireAdministrator(s, i, g, "panicaudit") {
+		return
+	}
+	request := PanicAuditRequest{Limit: 10}
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case "event":
+			request.Event = option.StringValue()
+		case "limit":
+			request.Limit = int(option.IntValue())
+		}
+	}
+	response := d.panicAuditCallback(g.id, i.Member.User.ID, request)
+	err := respondEphemeral(s, i, response)
+	if err != nil {
+		d.logger.Errorf("failed to respond to application command: %s", err.Error())
+	}
+	metrics.SlashCommands.WithLabelValues("panicaudit", commandOutcome(err)).Inc()
+}
@@ -0,0 +1,129 @@
+package panicbot
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot/internal/metrics"
+)
+
+const (
+	// PanicConfigView shows the current settings of the guild.
+	PanicConfigView = "view"
+	// PanicConfigSet changes a setting of the guild.
+	PanicConfigSet = "set"
+	// PanicConfigReset reverts a setting of the guild to the value in the config file.
+	PanicConfigReset = "reset"
+)
+
+// PanicConfigRequest is a /panicconfig subcommand used by an administrator of a guild.
+type PanicConfigRequest struct {
+	// Action is PanicConfigView, PanicConfigSet or PanicConfigReset.
+	Action string
+	// Setting and Value are empty for PanicConfigView, Value is empty for PanicConfigReset.
+	Setting string
+	Value   string
+}
+
+// panicConfigCommand builds the /panicconfig command, settings are offered as the choices of the setting option.
+func panicConfigCommand(settings []string) *discordgo.ApplicationCommand {
+	var def bool = false
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(settings))
+	for _, setting := range settings {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: setting, Value: setting})
+	}
+	settingOption := &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "setting",
+		Description: "The setting to change.",
+		Required:    true,
+		Choices:     choices,
+	}
+	return &discordgo.ApplicationCommand{
+		Name:              "panicconfig",
+		Description:       "View or change the panicbot settings of this server. Administrators only.",
+		DefaultPermission: &def,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        PanicConfigView,
+				Description: "Show the current settings.",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        PanicConfigSet,
+				Description: "Change a setting.",
+				Options: []*discordgo.ApplicationCommandOption{
+					settingOption,
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "value",
+						Description: "The new value. Separate lists with commas, use none for an empty list.",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        PanicConfigReset,
+				Description: "Revert a setting to the value in the config file.",
+				Options:     []*discordgo.ApplicationCommandOption{settingOption},
+			},
+		},
+	}
+}
+
+// handlePanicConfig passes a /panicconfig subcommand of an administrator to the PanicConfigCallback and shows its
+// response to the administrator only.
+func (d *DiscordImpl) handlePanicConfig(s *discordgo.Session, i *discordgo.InteractionCreate, g *guild) {
+	if !d.requireAdministrator(s, i, g, "panicconfig") {
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		err := respondEphemeral(s, i, fmt.Sprintf("Usage: /panicconfig %s, /panicconfig %s <setting> <value> or /panicconfig %s <setting>.", PanicConfigView, PanicConfigSet, PanicConfigReset))
+		if err != nil {
+			d.logger.Errorf("failed to respond to application command: %s", err.Error())
+		}
+		metrics.SlashCommands.WithLabelValues("panicconfig", commandOutcome(err)).Inc()
+		return
+	}
+	subcommand := data.Options[0]
+	request := PanicConfigRequest{Action: subcommand.Name}
+	for _, option := range subcommand.Options {
+		switch option.Name {
+		case "setting":
+			request.Setting = option.StringValue()
+		case "value":
+			request.Value = option.StringValue()
+		}
+	}
+	response := d.panicConfigCallback(g.id, i.Member.User.ID, request)
+	err := respondEphemeral(s, i, response)
+	if err != nil {
+		d.logger.Errorf("failed to respond to application command: %s", err.Error())
+	}
+	metrics.SlashCommands.WithLabelValues("panicconfig", commandOutcome(err)).Inc()
+}
+
+// requireAdministrator turns down the command unless the member is an administrator of the guild.
+func (d *DiscordImpl) requireAdministrator(s *discordgo.Session, i *discordgo.InteractionCreate, g *guild, command string) bool {
+	if i.Member.Permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	d.logger.WithFields(log.Fields{
+		"command": command,
+		"guildID": g.id,
+		"userID":  i.Member.User.ID,
+	}).Warn("rejected command from a member that is not an administrator")
+	metrics.SlashCommands.WithLabelValues(command, metrics.CommandDenied).Inc()
+	if d.permissionDeniedCallback != nil {
+		d.permissionDeniedCallback(g.id, i.Member.User.ID, command)
+	}
+	err := respondEphemeral(s, i, fmt.Sprintf("Only administrators can use /%s.", command))
+	if err != nil {
+		d.logger.Errorf("failed to respond to application command: %s", err.Error())
+	}
+	return false
+}
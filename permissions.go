@@ -0,0 +1,125 @@
+package panicbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/streemtech/panicbot/internal/slice"
+)
+
+const (
+	// RoleMatchAny allows members holding at least one of the roles of a PermissionRule.
+	RoleMatchAny = "any"
+	// RoleMatchAll only allows members holding every role of a PermissionRule.
+	RoleMatchAll = "all"
+)
+
+// permissionNames maps the names used in the config to Discord permission bits.
+var permissionNames = map[string]int64{
+	"ADMINISTRATOR":    discordgo.PermissionAdministrator,
+	"BAN_MEMBERS":      discordgo.PermissionBanMembers,
+	"KICK_MEMBERS":     discordgo.PermissionKickMembers,
+	"MANAGE_GUILD":     discordgo.PermissionManageServer,
+	"MANAGE_ROLES":     discordgo.PermissionManageRoles,
+	"MANAGE_CHANNELS":  discordgo.PermissionManageChannels,
+	"MANAGE_MESSAGES":  discordgo.PermissionManageMessages,
+	"MODERATE_MEMBERS": discordgo.PermissionModerateMembers,
+	"VIEW_AUDIT_LOG":   discordgo.PermissionViewAuditLogs,
+}
+
+// PermissionRule decides which members may use a panic command and vote on it.
+type PermissionRule struct {
+	// Users and Roles allow members by ID.
+	Users []string
+	Roles []string
+	// DenyUsers and DenyRoles take precedence over every other setting.
+	DenyUsers []string
+	DenyRoles []string
+	// Permissions allows members that hold all of the listed Discord permissions, e.g. BAN_MEMBERS.
+	Permissions []string
+	// RoleMatch is either RoleMatchAny (the default) or RoleMatchAll.
+	RoleMatch string
+}
+
+// Allows reports whether member is permitted by the rule.
+func (r PermissionRule) Allows(member UserRoles) bool {
+	if member.UserID != "" && slice.Contains(r.DenyUsers, member.UserID) {
+		return false
+	}
+	for _, role := range member.Roles {
+		if slice.Contains(r.DenyRoles, role) {
+			return false
+		}
+	}
+
+	if member.UserID != "" && slice.Contains(r.Users, member.UserID) {
+		return true
+	}
+	if r.rolesMatch(member.Roles) {
+		return true
+	}
+	return r.permissionsMatch(member.Permissions)
+}
+
+func (r PermissionRule) rolesMatch(memberRoles []string) bool {
+	roles := make([]string, 0, len(r.Roles))
+	for _, role := range r.Roles {
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return false
+	}
+	if strings.EqualFold(r.RoleMatch, RoleMatchAll) {
+		for _, role := range roles {
+			if !slice.Contains(memberRoles, role) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, role := range roles {
+		if slice.Contains(memberRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PermissionRule) permissionsMatch(memberPermissions int64) bool {
+	if len(r.Permissions) == 0 {
+		return false
+	}
+	required, err := ParsePermissions(r.Permissions)
+	if err != nil {
+		return false
+	}
+	if memberPermissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+	return memberPermissions&required == required
+}
+
+// Validate checks that RoleMatch and Permissions contain known values.
+func (r PermissionRule) Validate() error {
+	if r.RoleMatch != "" && !strings.EqualFold(r.RoleMatch, RoleMatchAny) && !strings.EqualFold(r.RoleMatch, RoleMatchAll) {
+		return fmt.Errorf("RoleMatch must be either %s or %s, got: %s", RoleMatchAny, RoleMatchAll, r.RoleMatch)
+	}
+	_, err := ParsePermissions(r.Permissions)
+	return err
+}
+
+// ParsePermissions combines the named Discord permissions into a single bit set.
+func ParsePermissions(names []string) (int64, error) {
+	var permissions int64
+	for _, name := range names {
+		permission, ok := permissionNames[strings.ToUpper(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission: %s", name)
+		}
+		permissions |= permission
+	}
+	return permissions, nil
+}
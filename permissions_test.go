@@ -0,0 +1,168 @@
+package panicbot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestPermissionRuleAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   PermissionRule
+		member UserRoles
+		want   bool
+	}{
+		{
+			name:   "mod role listed first",
+			rule:   PermissionRule{Roles: []string{"mod", "helper"}},
+			member: UserRoles{UserID: "1", Roles: []string{"mod", "member"}},
+			want:   true,
+		},
+		{
+			name:   "mod role listed last",
+			rule:   PermissionRule{Roles: []string{"helper", "mod"}},
+			member: UserRoles{UserID: "1", Roles: []string{"member", "mod"}},
+			want:   true,
+		},
+		{
+			name:   "no matching role",
+			rule:   PermissionRule{Roles: []string{"helper", "mod"}},
+			member: UserRoles{UserID: "1", Roles: []string{"member"}},
+			want:   false,
+		},
+		{
+			name:   "allowed user",
+			rule:   PermissionRule{Users: []string{"1"}},
+			member: UserRoles{UserID: "1"},
+			want:   true,
+		},
+		{
+			name:   "denied user overrides allowed user",
+			rule:   PermissionRule{Users: []string{"1"}, DenyUsers: []string{"1"}},
+			member: UserRoles{UserID: "1"},
+			want:   false,
+		},
+		{
+			name:   "denied role overrides allowed role",
+			rule:   PermissionRule{Roles: []string{"mod"}, DenyRoles: []string{"muted"}},
+			member: UserRoles{UserID: "1", Roles: []string{"mod", "muted"}},
+			want:   false,
+		},
+		{
+			name:   "denied role overrides permissions",
+			rule:   PermissionRule{Permissions: []string{"BAN_MEMBERS"}, DenyRoles: []string{"muted"}},
+			member: UserRoles{UserID: "1", Roles: []string{"muted"}, Permissions: discordgo.PermissionAdministrator},
+			want:   false,
+		},
+		{
+			name:   "role match any with one role",
+			rule:   PermissionRule{Roles: []string{"mod", "helper"}, RoleMatch: RoleMatchAny},
+			member: UserRoles{UserID: "1", Roles: []string{"helper"}},
+			want:   true,
+		},
+		{
+			name:   "role match all with one role",
+			rule:   PermissionRule{Roles: []string{"mod", "helper"}, RoleMatch: RoleMatchAll},
+			member: UserRoles{UserID: "1", Roles: []string{"helper"}},
+			want:   false,
+		},
+		{
+			name:   "role match all with every role",
+			rule:   PermissionRule{Roles: []string{"mod", "helper"}, RoleMatch: "ALL"},
+			member: UserRoles{UserID: "1", Roles: []string{"helper", "member", "mod"}},
+			want:   true,
+		},
+		{
+			name:   "holds every permission",
+			rule:   PermissionRule{Permissions: []string{"BAN_MEMBERS", "kick_members"}},
+			member: UserRoles{UserID: "1", Permissions: discordgo.PermissionBanMembers | discordgo.PermissionKickMembers},
+			want:   true,
+		},
+		{
+			name:   "holds some of the permissions",
+			rule:   PermissionRule{Permissions: []string{"BAN_MEMBERS", "KICK_MEMBERS"}},
+			member: UserRoles{UserID: "1", Permissions: discordgo.PermissionBanMembers},
+			want:   false,
+		},
+		{
+			name:   "administrator bypasses permissions",
+			rule:   PermissionRule{Permissions: []string{"BAN_MEMBERS", "KICK_MEMBERS"}},
+			member: UserRoles{UserID: "1", Permissions: discordgo.PermissionAdministrator},
+			want:   true,
+		},
+		{
+			name:   "administrator without a permission rule",
+			rule:   PermissionRule{Roles: []string{"mod"}},
+			member: UserRoles{UserID: "1", Permissions: discordgo.PermissionAdministrator},
+			want:   false,
+		},
+		{
+			name:   "unknown permission allows nobody",
+			rule:   PermissionRule{Permissions: []string{"NOT_A_PERMISSION"}},
+			member: UserRoles{UserID: "1", Permissions: discordgo.PermissionAdministrator},
+			want:   false,
+		},
+		{
+			name:   "placeholder roles do not match a member without roles",
+			rule:   PermissionRule{Roles: []string{""}},
+			member: UserRoles{UserID: "1"},
+			want:   false,
+		},
+		{
+			name:   "placeholder roles do not match an empty role",
+			rule:   PermissionRule{Roles: []string{""}, RoleMatch: RoleMatchAll},
+			member: UserRoles{UserID: "1", Roles: []string{""}},
+			want:   false,
+		},
+		{
+			name:   "placeholder users do not match a member without an ID",
+			rule:   PermissionRule{Users: []string{""}},
+			member: UserRoles{},
+			want:   false,
+		},
+		{
+			name:   "placeholder deny lists do not deny",
+			rule:   PermissionRule{Roles: []string{"mod"}, DenyUsers: []string{""}, DenyRoles: []string{""}},
+			member: UserRoles{UserID: "1", Roles: []string{"mod"}},
+			want:   true,
+		},
+		{
+			name:   "empty rule allows nobody",
+			rule:   PermissionRule{},
+			member: UserRoles{UserID: "1", Roles: []string{"mod"}, Permissions: discordgo.PermissionAdministrator},
+			want:   false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.rule.Allows(test.member)
+			if got != test.want {
+				t.Errorf("Allows(%+v) = %t, want %t", test.member, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPermissionRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    PermissionRule
+		wantErr bool
+	}{
+		{name: "empty", rule: PermissionRule{}},
+		{name: "role match any", rule: PermissionRule{RoleMatch: RoleMatchAny}},
+		{name: "role match all", rule: PermissionRule{RoleMatch: "All"}},
+		{name: "unknown role match", rule: PermissionRule{RoleMatch: "most"}, wantErr: true},
+		{name: "known permissions", rule: PermissionRule{Permissions: []string{"ADMINISTRATOR", "ban_members"}}},
+		{name: "unknown permission", rule: PermissionRule{Permissions: []string{"BAN_EVERYONE"}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.rule.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() = %v, wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
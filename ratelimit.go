@@ -0,0 +1,88 @@
+package panicbot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimit caps how many votes of each panic command can be started in the guild per hour and per day.
+// A limit of -1, or anything below 1, means unlimited.
+type RateLimit struct {
+	PanicAlert struct {
+		Day  int
+		Hour int
+	}
+	PanicBan struct {
+		Day  int
+		Hour int
+	}
+}
+
+// rateLimiter keeps the start times of the votes of the last day for each command and checks them against
+// sliding one hour and one day windows.
+type rateLimiter struct {
+	mu     sync.Mutex
+	starts map[string][]time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		starts: make(map[string][]time.Time),
+	}
+}
+
+// allow checks whether a vote of command may be started, it does not count the vote. When a window is full it
+// returns false along with an explanation that can be shown to the user. Call record once the vote was started.
+func (r *rateLimiter) allow(command string, hourLimit, dayLimit int, now time.Time) (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	starts := r.prune(command, now)
+
+	windows := []struct {
+		name   string
+		length time.Duration
+		limit  int
+	}{
+		{name: "hour", length: time.Hour, limit: hourLimit},
+		{name: "day", length: time.Hour * 24, limit: dayLimit},
+	}
+	for _, window := range windows {
+		if window.limit < 1 {
+			continue
+		}
+		inWindow := make([]time.Time, 0, len(starts))
+		for _, start := range starts {
+			if now.Sub(start) < window.length {
+				inWindow = append(inWindow, start)
+			}
+		}
+		if len(inWindow) < window.limit {
+			continue
+		}
+		// The window frees up once the oldest vote that still counts against the limit falls out of it.
+		retry := inWindow[len(inWindow)-window.limit].Add(window.length).Sub(now)
+		return false, fmt.Sprintf("The limit of %d /%s votes per %s has been reached for this server. Try again in %s.", window.limit, command, window.name, retry.Round(time.Second))
+	}
+	return true, ""
+}
+
+// record counts a vote of command that was started at now against both windows.
+func (r *rateLimiter) record(command string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts[command] = append(r.prune(command, now), now)
+}
+
+// prune drops the starts of command that fell out of the largest window and returns the rest. r.mu must be held.
+func (r *rateLimiter) prune(command string, now time.Time) []time.Time {
+	starts := r.starts[command]
+	kept := starts[:0]
+	for _, start := range starts {
+		if now.Sub(start) < time.Hour*24 {
+			kept = append(kept, start)
+		}
+	}
+	r.starts[command] = kept
+	return kept
+}
@@ -0,0 +1,48 @@
+package panicbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterOnlyCountsRecordedVotes(t *testing.T) {
+	r := newRateLimiter()
+	now := time.Now()
+
+	for n := 0; n < 5; n++ {
+		if allowed, reason := r.allow("panicban", 1, 0, now); !allowed {
+			t.Fatalf("expected votes that were never recorded not to count against the limit: %s", reason)
+		}
+	}
+	r.record("panicban", now)
+	allowed, reason := r.allow("panicban", 1, 0, now.Add(time.Minute))
+	if allowed {
+		t.Fatalf("expected the recorded vote to fill the hourly window")
+	}
+	if !strings.Contains(reason, "per hour") || !strings.Contains(reason, "59m0s") {
+		t.Errorf("unexpected reason: %s", reason)
+	}
+	if allowed, _ := r.allow("panicalert", 1, 0, now.Add(time.Minute)); !allowed {
+		t.Errorf("expected the limit of another command not to apply")
+	}
+	if allowed, reason := r.allow("panicban", 1, 0, now.Add(time.Hour)); !allowed {
+		t.Errorf("expected the hourly window to free up after an hour: %s", reason)
+	}
+}
+
+func TestRateLimiterDailyWindow(t *testing.T) {
+	r := newRateLimiter()
+	start := time.Now()
+	for n := 0; n < 3; n++ {
+		r.record("panicalert", start.Add(time.Hour*time.Duration(n*2)))
+	}
+
+	allowed, reason := r.allow("panicalert", 2, 3, start.Add(time.Hour*5))
+	if allowed || !strings.Contains(reason, "per day") {
+		t.Fatalf("expected the daily limit to be reached, got allowed=%t %s", allowed, reason)
+	}
+	if allowed, reason := r.allow("panicalert", 2, 3, start.Add(time.Hour*24)); !allowed {
+		t.Errorf("expected the oldest vote to fall out of the daily window: %s", reason)
+	}
+}
@@ -1,50 +1,214 @@
 package panicbot
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
+	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/streemtech/panicbot/internal/baseurl"
+	"github.com/streemtech/panicbot/internal/metrics"
 	"github.com/twilio/twilio-go"
+	"github.com/twilio/twilio-go/client"
 	api "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
 type Twilio interface {
 	SendMessage(toNumber, body string) error
+	Call(toNumber, message string) error
+	// CheckCredentials verifies that the account can be accessed with the configured API key.
+	CheckCredentials() error
 }
 type TwilioImpl struct {
 	accountSID        string
 	apiKey            string
 	apiSecret         string
 	twilioPhoneNumber string
-	logger            *log.Logger
-	client            *twilio.RestClient
+	callAttempts      int
+	callRetryDelay    time.Duration
+	callPollInterval  time.Duration
+	statusCallbackURL string
+	authToken         string
+	maxRetries        int
+	retryBackoff      time.Duration
+	deliveries        *deliveryTracker
+	// deliveryFailureCallback is called for messages that could not be delivered after every retry.
+	deliveryFailureCallback func(delivery MessageDelivery)
+	logger                  *log.Logger
+	client                  *twilio.RestClient
 }
 type TwilioImplArgs struct {
 	AccountSID        string
 	APIKey            string
 	APISecret         string
 	TwilioPhoneNumber string
-	Logger            *log.Logger
-	Client            *twilio.RestClient
+	// CallAttempts is how many times a call is placed before giving up, 1 places a single call without retries.
+	// Defaults to three when nil.
+	CallAttempts *int
+	// CallRetryDelay is the time waited between two unanswered calls. Defaults to thirty seconds.
+	CallRetryDelay time.Duration
+	// CallPollInterval is how often the status of a ringing call is checked. Defaults to five seconds.
+	CallPollInterval time.Duration
+	// StatusCallbackURL is the public URL of StatusCallbackHandler. Delivery tracking is disabled when empty.
+	StatusCallbackURL string
+	// AuthToken of the Twilio account, used to check the X-Twilio-Signature of status callbacks.
+	AuthToken string
+	// MaxRetries is how many times an undelivered message is sent again, 0 disables retries. Defaults to three when
+	// nil.
+	MaxRetries *int
+	// RetryBackoff is the delay before the first retry, it doubles for every following retry. Defaults to one minute.
+	RetryBackoff            time.Duration
+	DeliveryFailureCallback func(delivery MessageDelivery)
+	// BaseURL is optional. When set every request is sent to it instead of the Twilio API, e.g. to a local server.
+	BaseURL string
+	Logger  *log.Logger
+	Client  *twilio.RestClient
 }
 
 var _ Twilio = (*TwilioImpl)(nil)
 
 func (Twilio *TwilioImpl) SendMessage(toNumber, body string) error {
+	_, err := Twilio.sendMessage(toNumber, body, 1)
+	metrics.TwilioSends.WithLabelValues("sms", metrics.Result(err)).Inc()
+	return err
+}
+
+func (Twilio *TwilioImpl) sendMessage(toNumber, body string, attempt int) (string, error) {
 	params := &api.CreateMessageParams{}
 	params.SetTo(toNumber)
 	params.SetFrom(Twilio.twilioPhoneNumber)
 	params.SetBody(body)
+	if Twilio.statusCallbackURL != "" {
+		params.SetStatusCallback(Twilio.statusCallbackURL)
+	}
 
 	resp, err := Twilio.client.Api.CreateMessage(params)
 	if err != nil {
 		Twilio.logger.Errorf("Error: %s", err.Error())
-		return fmt.Errorf("failed to send message: %s from %s to %s", body, Twilio.twilioPhoneNumber, toNumber)
+		return "", fmt.Errorf("failed to send message: %s from %s to %s", body, Twilio.twilioPhoneNumber, toNumber)
+	}
+	if resp.Sid == nil {
+		return "", fmt.Errorf("message to %s was created without a Sid", toNumber)
 	}
 
 	Twilio.logger.Debugf("Message Sid: %s", *resp.Sid)
+	if Twilio.statusCallbackURL != "" {
+		status := ""
+		if resp.Status != nil {
+			status = *resp.Status
+		}
+		Twilio.deliveries.track(MessageDelivery{
+			MessageSID: *resp.Sid,
+			To:         toNumber,
+			Body:       body,
+			Status:     status,
+			Attempt:    attempt,
+			UpdatedAt:  time.Now(),
+		})
+	}
+	return *resp.Sid, nil
+}
+
+// CheckCredentials lists a single message of the account. Standard API keys cannot fetch the account itself, but
+// they can read its messages.
+func (Twilio *TwilioImpl) CheckCredentials() error {
+	params := &api.ListMessageParams{}
+	params.SetPageSize(1)
+	_, err := Twilio.client.Api.PageMessage(params, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to list messages of Twilio account %s: %w", Twilio.accountSID, err)
+	}
 	return nil
 }
+
+// Call phones toNumber and reads message out loud. Calls that are not answered are placed again until
+// CallAttempts is reached.
+func (Twilio *TwilioImpl) Call(toNumber, message string) (err error) {
+	defer func() {
+		metrics.TwilioSends.WithLabelValues("call", metrics.Result(err)).Inc()
+	}()
+	twiml, err := sayTwiML(message)
+	if err != nil {
+		return fmt.Errorf("failed to build TwiML for call to %s: %w", toNumber, err)
+	}
+	status := ""
+	for attempt := 1; attempt <= Twilio.callAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(Twilio.callRetryDelay)
+		}
+		status, err = Twilio.placeCall(toNumber, twiml)
+		if err != nil {
+			return err
+		}
+		if callAnswered(status) {
+			return nil
+		}
+		Twilio.logger.WithFields(log.Fields{
+			"to":      toNumber,
+			"status":  status,
+			"attempt": attempt,
+		}).Warn("call was not answered")
+	}
+	return fmt.Errorf("call to %s was not answered after %d attempts, last status: %s", toNumber, Twilio.callAttempts, status)
+}
+
+// placeCall starts a call and waits until it has either been answered or ended. It returns the last call status.
+func (Twilio *TwilioImpl) placeCall(toNumber, twiml string) (string, error) {
+	params := &api.CreateCallParams{}
+	params.SetTo(toNumber)
+	params.SetFrom(Twilio.twilioPhoneNumber)
+	params.SetTwiml(twiml)
+
+	resp, err := Twilio.client.Api.CreateCall(params)
+	if err != nil {
+		Twilio.logger.Errorf("Error: %s", err.Error())
+		return "", fmt.Errorf("failed to call %s from %s", toNumber, Twilio.twilioPhoneNumber)
+	}
+	if resp.Sid == nil {
+		return "", fmt.Errorf("call to %s was created without a Sid", toNumber)
+	}
+	Twilio.logger.Debugf("Call Sid: %s", *resp.Sid)
+
+	status := ""
+	if resp.Status != nil {
+		status = *resp.Status
+	}
+	for !callAnswered(status) && !callEnded(status) {
+		time.Sleep(Twilio.callPollInterval)
+		call, err := Twilio.client.Api.FetchCall(*resp.Sid, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch status of call %s to %s: %w", *resp.Sid, toNumber, err)
+		}
+		if call.Status != nil {
+			status = *call.Status
+		}
+	}
+	return status, nil
+}
+
+// callAnswered reports whether a call with the given status was picked up.
+func callAnswered(status string) bool {
+	return status == "in-progress" || status == "completed"
+}
+
+// callEnded reports whether a call with the given status ended without being picked up.
+func callEnded(status string) bool {
+	return status == "busy" || status == "failed" || status == "no-answer" || status == "canceled"
+}
+
+// sayTwiML builds the TwiML that reads message to whoever answers the call. The message is repeated so that it
+// can still be understood by someone who just woke up.
+func sayTwiML(message string) (string, error) {
+	escaped := &bytes.Buffer{}
+	err := xml.EscapeText(escaped, []byte(message))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><Response><Say loop="3">%s</Say></Response>`, escaped.String()), nil
+}
+
 func NewTwilio(args *TwilioImplArgs) (*TwilioImpl, error) {
 	if args.AccountSID == "" {
 		return nil, fmt.Errorf("AccountSID cannot be empty. Did you forget to set it in the config?")
@@ -61,18 +225,81 @@ func NewTwilio(args *TwilioImplArgs) (*TwilioImpl, error) {
 		return nil, fmt.Errorf("TwilioPhoneNumber cannot be empty. Did you forget to set it in the config?")
 	}
 
-	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username:   args.APIKey,
-		Password:   args.APISecret,
-		AccountSid: args.AccountSID,
-	})
+	if args.StatusCallbackURL != "" && args.AuthToken == "" {
+		return nil, fmt.Errorf("AuthToken cannot be empty when StatusCallbackURL is set. Did you forget to set it in the config?")
+	}
+
+	restClient := args.Client
+	if restClient == nil {
+		httpClient := &http.Client{
+			// Like the default Twilio client, return redirects instead of following them.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Timeout: time.Second * 10,
+		}
+		if args.BaseURL != "" {
+			transport, err := baseurl.NewTransport(args.BaseURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BaseURL: %w", err)
+			}
+			httpClient.Transport = transport
+		}
+		baseClient := &client.Client{
+			Credentials: client.NewCredentials(args.APIKey, args.APISecret),
+			HTTPClient:  httpClient,
+		}
+		baseClient.SetAccountSid(args.AccountSID)
+		restClient = twilio.NewRestClientWithParams(twilio.ClientParams{
+			Client: baseClient,
+		})
+	}
+
+	callAttempts := 3
+	if args.CallAttempts != nil {
+		callAttempts = *args.CallAttempts
+	}
+	if callAttempts < 1 {
+		return nil, fmt.Errorf("CallAttempts must be at least 1, got %d", callAttempts)
+	}
+	callRetryDelay := args.CallRetryDelay
+	if callRetryDelay <= 0 {
+		callRetryDelay = time.Second * 30
+	}
+	callPollInterval := args.CallPollInterval
+	if callPollInterval <= 0 {
+		callPollInterval = time.Second * 5
+	}
+
+	maxRetries := 3
+	if args.MaxRetries != nil {
+		maxRetries = *args.MaxRetries
+	}
+	if maxRetries < 0 {
+		return nil, fmt.Errorf("MaxRetries cannot be negative, got %d", maxRetries)
+	}
+	retryBackoff := args.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = time.Minute
+	}
+
 	twilioImpl := &TwilioImpl{
 		accountSID:        args.AccountSID,
 		apiKey:            args.APIKey,
 		apiSecret:         args.APISecret,
 		twilioPhoneNumber: args.TwilioPhoneNumber,
-		client:            client,
+		callAttempts:      callAttempts,
+		callRetryDelay:    callRetryDelay,
+		callPollInterval:  callPollInterval,
+		statusCallbackURL: args.StatusCallbackURL,
+		authToken:         args.AuthToken,
+		maxRetries:        maxRetries,
+		retryBackoff:      retryBackoff,
+		deliveries:        newDeliveryTracker(),
+		client:            restClient,
 		logger:            args.Logger,
+
+		deliveryFailureCallback: args.DeliveryFailureCallback,
 	}
 	return twilioImpl, nil
 }
@@ -0,0 +1,159 @@
+package panicbot
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/twilio/twilio-go/client"
+)
+
+// MessageDelivery is the last known delivery state of a text message sent through Twilio.
+type MessageDelivery struct {
+	MessageSID string
+	To         string
+	Body       string
+	Status     string
+	// Attempt is 1 for the first send and is increased for every retry.
+	Attempt   int
+	UpdatedAt time.Time
+}
+
+// deliveryTrackingTimeout is how long a message that never reaches a final status is tracked. Not every carrier
+// reports delivery, so such messages would otherwise be kept forever.
+const deliveryTrackingTimeout = time.Hour * 24
+
+// deliveryTracker records the status of every message that was sent with a StatusCallback until it reaches a final
+// status or deliveryTrackingTimeout has passed since its last update.
+type deliveryTracker struct {
+	mu       sync.Mutex
+	messages map[string]MessageDelivery
+}
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{
+		messages: make(map[string]MessageDelivery),
+	}
+}
+
+// track starts tracking a message and forgets the messages that have not been updated in deliveryTrackingTimeout.
+func (d *deliveryTracker) track(delivery MessageDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for messageSID, tracked := range d.messages {
+		if delivery.UpdatedAt.Sub(tracked.UpdatedAt) > deliveryTrackingTimeout {
+			delete(d.messages, messageSID)
+		}
+	}
+	d.messages[delivery.MessageSID] = delivery
+}
+
+// update sets the status of a tracked message. ok is false for messages that were not sent by this process or are no
+// longer tracked. A message that reaches a final status is no longer tracked; a retry of an undelivered message is
+// tracked under its own MessageSID.
+func (d *deliveryTracker) update(messageSID, status string, now time.Time) (delivery MessageDelivery, changed bool, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery, ok = d.messages[messageSID]
+	if !ok {
+		return MessageDelivery{}, false, false
+	}
+	changed = delivery.Status != status
+	delivery.Status = status
+	delivery.UpdatedAt = now
+	if messageFinished(status) {
+		delete(d.messages, messageSID)
+		return delivery, changed, true
+	}
+	d.messages[messageSID] = delivery
+	return delivery, changed, true
+}
+
+func (d *deliveryTracker) get(messageSID string) (MessageDelivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery, ok := d.messages[messageSID]
+	return delivery, ok
+}
+
+// messageFinished reports whether Twilio will not report another status for a message with the given status.
+func messageFinished(status string) bool {
+	return status == "delivered" || status == "read" || status == "undelivered" || status == "failed" || status == "canceled"
+}
+
+// MessageStatus returns the last status Twilio reported for the message while it is being delivered. Messages are
+// forgotten once they reach a final status such as delivered.
+func (Twilio *TwilioImpl) MessageStatus(messageSID string) (MessageDelivery, bool) {
+	return Twilio.deliveries.get(messageSID)
+}
+
+// StatusCallbackHandler receives the StatusCallback webhooks of the messages sent by SendMessage. Requests without
+// a valid X-Twilio-Signature are rejected. Undelivered and failed messages are sent again with an exponential
+// backoff until MaxRetries is reached, after which the DeliveryFailureCallback is called.
+func (Twilio *TwilioImpl) StatusCallbackHandler() http.Handler {
+	validator := client.NewRequestValidator(Twilio.authToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		err := r.ParseForm()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		params := make(map[string]string, len(r.PostForm))
+		for key := range r.PostForm {
+			params[key] = r.PostForm.Get(key)
+		}
+		if !validator.Validate(Twilio.statusCallbackURL, params, r.Header.Get("X-Twilio-Signature")) {
+			Twilio.logger.Warnf("rejected Twilio status callback with an invalid signature from %s", r.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		messageSID := r.PostForm.Get("MessageSid")
+		status := r.PostForm.Get("MessageStatus")
+		delivery, changed, ok := Twilio.deliveries.update(messageSID, status, time.Now())
+		if !ok {
+			Twilio.logger.Debugf("received status %s for unknown message %s", status, messageSID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		Twilio.logger.WithFields(log.Fields{
+			"messageSID": messageSID,
+			"to":         delivery.To,
+			"status":     status,
+			"attempt":    delivery.Attempt,
+		}).Debug("Twilio message status changed")
+
+		// Twilio may send the same status more than once, only act on the first one.
+		if changed && (status == "undelivered" || status == "failed") {
+			Twilio.handleFailedDelivery(delivery, r.PostForm.Get("ErrorCode"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (Twilio *TwilioImpl) handleFailedDelivery(delivery MessageDelivery, errorCode string) {
+	if delivery.Attempt > Twilio.maxRetries {
+		Twilio.logger.Errorf("message %s to %s was %s after %d attempts (error code %s)", delivery.MessageSID, delivery.To, delivery.Status, delivery.Attempt, errorCode)
+		if Twilio.deliveryFailureCallback != nil {
+			Twilio.deliveryFailureCallback(delivery)
+		}
+		return
+	}
+	backoff := Twilio.retryBackoff * time.Duration(1<<(delivery.Attempt-1))
+	Twilio.logger.Warnf("message %s to %s was %s (error code %s), retrying in %s", delivery.MessageSID, delivery.To, delivery.Status, errorCode, backoff)
+	time.AfterFunc(backoff, func() {
+		_, err := Twilio.sendMessage(delivery.To, delivery.Body, delivery.Attempt+1)
+		if err != nil {
+			Twilio.logger.Errorf("failed to retry message to %s: %s", delivery.To, err.Error())
+			if Twilio.deliveryFailureCallback != nil {
+				delivery.Status = "failed"
+				Twilio.deliveryFailureCallback(delivery)
+			}
+		}
+	})
+}
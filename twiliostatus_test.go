@@ -0,0 +1,45 @@
+package panicbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveryTrackerForgetsFinishedMessages(t *testing.T) {
+	d := newDeliveryTracker()
+	now := time.Now()
+	d.track(MessageDelivery{MessageSID: "SM1", Status: "queued", Attempt: 1, UpdatedAt: now})
+
+	delivery, changed, ok := d.update("SM1", "sent", now)
+	if !ok || !changed || delivery.Status != "sent" {
+		t.Fatalf("expected the message to be updated to sent, got %+v changed=%t ok=%t", delivery, changed, ok)
+	}
+	delivery, changed, ok = d.update("SM1", "undelivered", now)
+	if !ok || !changed || delivery.Status != "undelivered" {
+		t.Fatalf("expected the message to be updated to undelivered, got %+v changed=%t ok=%t", delivery, changed, ok)
+	}
+	if _, ok := d.get("SM1"); ok {
+		t.Errorf("expected the undelivered message to be forgotten")
+	}
+	// Twilio may repeat a status, it must not be acted on twice.
+	if _, _, ok := d.update("SM1", "undelivered", now); ok {
+		t.Errorf("expected a repeated final status to be ignored")
+	}
+}
+
+func TestDeliveryTrackerExpiresStaleMessages(t *testing.T) {
+	d := newDeliveryTracker()
+	start := time.Now()
+	d.track(MessageDelivery{MessageSID: "SM1", Status: "sent", UpdatedAt: start})
+	d.track(MessageDelivery{MessageSID: "SM2", Status: "sent", UpdatedAt: start.Add(deliveryTrackingTimeout / 2)})
+	d.track(MessageDelivery{MessageSID: "SM3", Status: "queued", UpdatedAt: start.Add(deliveryTrackingTimeout + time.Minute)})
+
+	if _, ok := d.get("SM1"); ok {
+		t.Errorf("expected the message without an update for longer than deliveryTrackingTimeout to be forgotten")
+	}
+	for _, messageSID := range []string{"SM2", "SM3"} {
+		if _, ok := d.get(messageSID); !ok {
+			t.Errorf("expected %s to still be tracked", messageSID)
+		}
+	}
+}